@@ -0,0 +1,338 @@
+package fsm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// AuditEvent describes an update processed by HandleUpdate.
+type AuditEvent struct {
+	Time       time.Time  `json:"time"`
+	ChatId     int64      `json:"chat_id"`
+	SessionKey SessionKey `json:"session_key"`
+	// Forum topic id the update came from.
+	ThreadId int `json:"thread_id,omitempty"`
+	// Data sent by the web app. It's not a part of Update, since tgbotapi doesn't support it.
+	WebAppData *WebAppData `json:"web_app_data,omitempty"`
+	UpdateId   int         `json:"update_id"`
+	// Update type, e.g. "message" or "callback_query".
+	UpdateType `json:"update_type"`
+	// Message text or callback query data.
+	Text string `json:"text,omitempty"`
+	// The whole update. It's used by Replay.
+	Update *tgbotapi.Update `json:"update,omitempty"`
+	// The loaded state. It's empty if state wasn't loaded.
+	PrevState State `json:"prev_state,omitempty"`
+	// Command name without "/" prefix.
+	Command string `json:"command,omitempty"`
+	// The state chosen by the transition. It's empty if processing failed before that.
+	NewState State `json:"new_state,omitempty"`
+	// Data fields changed by the transition.
+	DataDiff []DataChange `json:"data_diff,omitempty"`
+	// Messages sent to the chat.
+	Messages []AuditMessage `json:"messages,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// DataChange describes a changed data field. Data is compared in its JSON representation, Path is a dot-separated
+// JSON field path. It's empty if data is not a JSON object.
+type DataChange struct {
+	Path string `json:"path"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// AuditMessage describes a message sent to the chat.
+type AuditMessage struct {
+	Text        string `json:"text"`
+	ParseMode   string `json:"parse_mode,omitempty"`
+	ReplyMarkup any    `json:"reply_markup,omitempty"`
+}
+
+// AuditSink receives an event per update processed by HandleUpdate. Implementations must be safe for concurrent use.
+type AuditSink interface {
+	Audit(ctx context.Context, event AuditEvent) error
+}
+
+// WithAuditSink sets AuditSink. Sink errors don't affect update processing, they are only logged.
+func WithAuditSink[T any](sink AuditSink) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.auditSink = sink
+	}
+}
+
+// JSONLAuditSink is an AuditSink which writes events as JSON lines. Use NewJSONLAuditSink to create it.
+type JSONLAuditSink struct {
+	mx      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONLAuditSink creates JSONLAuditSink writing to w, e.g. to a file opened in append mode.
+func NewJSONLAuditSink(w io.Writer) *JSONLAuditSink {
+	return &JSONLAuditSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *JSONLAuditSink) Audit(ctx context.Context, event AuditEvent) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.encoder.Encode(event)
+}
+
+// ReadAuditEvents reads events written by JSONLAuditSink.
+func ReadAuditEvents(r io.Reader) ([]AuditEvent, error) {
+	var events []AuditEvent
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var event AuditEvent
+		err := decoder.Decode(&event)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
+
+// audit builds AuditEvent and passes it to the sink. updateContext is nil, if state wasn't loaded.
+func (b *BotFsm[T]) audit(
+	ctx context.Context,
+	logger fsmLogger,
+	update *tgbotapi.Update,
+	updateContext *UpdateContext[T],
+	err error,
+) {
+	if b.auditSink == nil {
+		return
+	}
+	event := AuditEvent{
+		Time:       time.Now(),
+		ChatId:     getChatId(update),
+		UpdateId:   update.UpdateID,
+		UpdateType: getUpdateType(update),
+		Update:     update,
+		Command:    extractCommand(update),
+	}
+	if update.Message != nil {
+		event.Text = update.Message.Text
+	} else if update.CallbackQuery != nil {
+		event.Text = update.CallbackQuery.Data
+	}
+	if updateContext != nil {
+		event.SessionKey = updateContext.SessionKey
+		event.ThreadId = updateContext.ThreadId
+		event.WebAppData = updateContext.WebAppData
+		event.PrevState = updateContext.State
+		event.NewState = updateContext.NewState
+		if updateContext.NewState != "" {
+			event.DataDiff = diffData(updateContext.Data, updateContext.NewData)
+		}
+		for _, msg := range updateContext.messages {
+			event.Messages = append(event.Messages, AuditMessage{
+				Text:        msg.Text,
+				ParseMode:   msg.ParseMode,
+				ReplyMarkup: msg.ReplyMarkup,
+			})
+		}
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if auditErr := b.auditSink.Audit(ctx, event); auditErr != nil {
+		logger.log(ctx, slog.LevelError, "audit failed", slog.String("error", auditErr.Error()))
+	}
+}
+
+// diffData compares JSON representations of data. Nested objects are compared field by field.
+func diffData(oldData any, newData any) []DataChange {
+	oldValue, oldErr := toJSONValue(oldData)
+	newValue, newErr := toJSONValue(newData)
+	if oldErr != nil || newErr != nil {
+		return nil
+	}
+	var changes []DataChange
+	diffJSONValues("", oldValue, newValue, &changes)
+	return changes
+}
+
+func toJSONValue(data any) (any, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	err = json.Unmarshal(encoded, &value)
+	return value, err
+}
+
+func diffJSONValues(path string, oldValue any, newValue any, changes *[]DataChange) {
+	oldObject, oldOk := oldValue.(map[string]any)
+	newObject, newOk := newValue.(map[string]any)
+	if !oldOk || !newOk {
+		if !reflect.DeepEqual(oldValue, newValue) {
+			*changes = append(*changes, DataChange{Path: path, Old: oldValue, New: newValue})
+		}
+		return
+	}
+	keys := make(map[string]struct{}, len(oldObject)+len(newObject))
+	for key := range oldObject {
+		keys[key] = struct{}{}
+	}
+	for key := range newObject {
+		keys[key] = struct{}{}
+	}
+	for _, key := range sortedKeys(keys) {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		diffJSONValues(keyPath, oldObject[key], newObject[key], changes)
+	}
+}
+
+// ReplayDifference describes a mismatch between recorded and replayed update processing.
+type ReplayDifference struct {
+	ChatId   int64
+	UpdateId int
+	// Mismatched AuditEvent field: "NewState", "Messages" or "Error".
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (d ReplayDifference) String() string {
+	return fmt.Sprintf("chat %d update %d: %s expected %q, got %q", d.ChatId, d.UpdateId, d.Field, d.Expected,
+		d.Actual)
+}
+
+// ReplayReport contains Replay results.
+type ReplayReport struct {
+	// Number of replayed updates.
+	Replayed    int
+	Differences []ReplayDifference
+}
+
+func (r *ReplayReport) HasDifferences() bool {
+	return len(r.Differences) > 0
+}
+
+// Replay re-runs recorded updates against the current configuration and reports behaviour changes: different saved
+// states, sent messages or errors. Replay uses in-memory persistence and doesn't send anything to Telegram. The first
+// update of every chat starts from its recorded previous state with empty data, so data is reproduced precisely only
+// for conversations recorded from the beginning. Handlers and middlewares are called as usual, so they should not
+// have side effects undesirable during replay. Transition observers, lifecycle hooks, metrics, tracer and error
+// handler are not used.
+func (b *BotFsm[T]) Replay(ctx context.Context, events []AuditEvent) (*ReplayReport, error) {
+	sink := &captureAuditSink{}
+	persistenceHandler := newPseudoPersistenceHandler[T]()
+	replayer := b.snapshot()
+	replayer.bot = replaySender{}
+	replayer.metaHandler = persistenceHandler
+	replayer.PersistenceHandler = persistenceHandler
+	replayer.transitionObservers = nil
+	replayer.errorHandler = nil
+	replayer.lifecycleHooks = LifecycleHooks{}
+	replayer.metrics = noopMetrics{}
+	replayer.tracer = noopTracer{}
+	replayer.logger = fsmLogger{}
+	replayer.auditSink = sink
+
+	report := &ReplayReport{}
+	started := make(map[SessionKey]struct{})
+	for _, event := range events {
+		if event.Update == nil {
+			continue
+		}
+		if _, ok := started[event.SessionKey]; !ok && event.PrevState != "" {
+			var emptyData T
+			err := persistenceHandler.SaveStateFn(ctx, event.SessionKey, event.PrevState, emptyData)
+			if err != nil {
+				return report, err
+			}
+		}
+		started[event.SessionKey] = struct{}{}
+
+		_ = replayer.handleUpdateWithExtras(ctx, event.Update, updateExtras{
+			threadId:   event.ThreadId,
+			webAppData: event.WebAppData,
+		})
+		report.Replayed++
+		report.Differences = append(report.Differences, compareAuditEvents(event, sink.last)...)
+	}
+	return report, nil
+}
+
+func compareAuditEvents(expected AuditEvent, actual AuditEvent) []ReplayDifference {
+	var differences []ReplayDifference
+	add := func(field string, expectedValue string, actualValue string) {
+		if expectedValue != actualValue {
+			differences = append(differences, ReplayDifference{
+				ChatId:   expected.ChatId,
+				UpdateId: expected.UpdateId,
+				Field:    field,
+				Expected: expectedValue,
+				Actual:   actualValue,
+			})
+		}
+	}
+	add("NewState", expected.NewState, actual.NewState)
+	add("Messages", auditMessagesText(expected.Messages), auditMessagesText(actual.Messages))
+	add("Error", expected.Error, actual.Error)
+	return differences
+}
+
+func auditMessagesText(messages []AuditMessage) string {
+	texts := make([]string, len(messages))
+	for i, message := range messages {
+		texts[i] = message.Text
+	}
+	encoded, _ := json.Marshal(texts)
+	return string(encoded)
+}
+
+// captureAuditSink keeps the last audited event.
+type captureAuditSink struct {
+	last AuditEvent
+}
+
+func (s *captureAuditSink) Audit(ctx context.Context, event AuditEvent) error {
+	s.last = event
+	return nil
+}
+
+// replaySender pretends that every message was sent successfully.
+type replaySender struct{}
+
+func (replaySender) Send(chattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var chatId int64
+	if msg, ok := chattable.(tgbotapi.MessageConfig); ok {
+		chatId = msg.ChatID
+	}
+	return tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatId}}, nil
+}
+
+func (replaySender) Request(chattable tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	return &tgbotapi.APIResponse{Ok: true, Result: []byte("true")}, nil
+}
+
+func (replaySender) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	result, err := json.Marshal(tgbotapi.Message{Chat: &tgbotapi.Chat{}})
+	if err != nil {
+		return nil, err
+	}
+	if chatId, parseErr := strconv.ParseInt(params["chat_id"], 10, 64); parseErr == nil {
+		result, err = json.Marshal(tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatId}})
+	}
+	return &tgbotapi.APIResponse{Ok: true, Result: result}, err
+}
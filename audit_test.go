@@ -0,0 +1,73 @@
+package fsm
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// counterBuilder builds a bot counting messages. The count is sent with prefix, and "stop" message leads to stopState.
+func counterBuilder(prefix string, stopState State) *Builder[int] {
+	builder := New[int]()
+	builder.State(UndefinedState).
+		Message(func(ctx context.Context, data int) MessageConfig {
+			return TextMessageConfig(prefix + strconv.Itoa(data))
+		}).
+		OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+			if update.Message.Text == "stop" {
+				return StateTransition(stopState), data
+			}
+			return StateTransition(UndefinedState), data + 1
+		})
+	builder.State("stopped").Text("stopped")
+	builder.State("paused").Text("paused")
+	return builder
+}
+
+func TestReplay(t *testing.T) {
+	_, bot := newFakeTelegram(t)
+	buf := &bytes.Buffer{}
+	recorded := counterBuilder("count ", "stopped").Build(bot, WithAuditSink[int](NewJSONLAuditSink(buf)))
+	ctx := context.Background()
+	for _, text := range []string{"a", "b", "stop"} {
+		if err := recorded.HandleUpdate(ctx, textUpdate(1, text)); err != nil {
+			t.Fatalf("update error: %s", err)
+		}
+	}
+	events, err := ReadAuditEvents(buf)
+	if err != nil {
+		t.Fatalf("read error: %s", err)
+	}
+
+	tests := []struct {
+		name        string
+		builder     *Builder[int]
+		differences []string
+	}{
+		{"same configuration", counterBuilder("count ", "stopped"), nil},
+		{"changed message", counterBuilder("total ", "stopped"), []string{"Messages", "Messages"}},
+		{"changed transition", counterBuilder("count ", "paused"), []string{"NewState", "Messages"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := test.builder.Build(nil).Replay(ctx, events)
+			if err != nil {
+				t.Fatalf("replay error: %s", err)
+			}
+			if report.Replayed != 3 {
+				t.Fatalf("expected 3 replayed updates, got %d", report.Replayed)
+			}
+			if len(report.Differences) != len(test.differences) {
+				t.Fatalf("expected differences %v, got %v", test.differences, report.Differences)
+			}
+			for i, difference := range report.Differences {
+				if difference.Field != test.differences[i] {
+					t.Fatalf("expected differences %v, got %v", test.differences, report.Differences)
+				}
+			}
+		})
+	}
+}
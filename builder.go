@@ -0,0 +1,295 @@
+package fsm
+
+import (
+	"context"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TransitionFn defines state switching logic as a plain function.
+type TransitionFn[T any] func(ctx context.Context, update *tgbotapi.Update, data T) (Transition, T)
+
+// HookFn defines OnEnter or OnExit hook as a plain function.
+type HookFn[T any] func(ctx context.Context, data T) (T, error)
+
+// Builder helps to define FSM with plain functions instead of a struct type per state. Use New to create it.
+type Builder[T any] struct {
+	states   map[State]*StateBuilder[T]
+	commands map[string]TransitionProvider[T]
+}
+
+// New creates a new FSM Builder. Note: like with NewBotFsm, UndefinedState must be defined.
+func New[T any]() *Builder[T] {
+	return &Builder[T]{
+		states:   make(map[State]*StateBuilder[T]),
+		commands: make(map[string]TransitionProvider[T]),
+	}
+}
+
+// State returns the state builder. Calling it again with the same name returns the same state builder.
+func (b *Builder[T]) State(state State) *StateBuilder[T] {
+	stateBuilder, ok := b.states[state]
+	if !ok {
+		stateBuilder = &StateBuilder[T]{builder: b, handler: &funcStateHandler[T]{}}
+		b.states[state] = stateBuilder
+	}
+	return stateBuilder
+}
+
+// Command adds command handler. Command name must be provided without "/" prefix.
+func (b *Builder[T]) Command(command string, fn TransitionFn[T]) *Builder[T] {
+	b.commands[command] = &funcCommandHandler[T]{transitionFn: fn}
+	return b
+}
+
+// CommandTo adds command switching bot to the given state.
+func (b *Builder[T]) CommandTo(command string, state State) *Builder[T] {
+	b.commands[command] = &funcCommandHandler[T]{
+		transitionFn: func(ctx context.Context, update *tgbotapi.Update, data T) (Transition, T) {
+			return StateTransition(state), data
+		},
+		targets: []State{state},
+	}
+	return b
+}
+
+// Configs returns states and commands configuration, which can be passed to NewBotFsm directly.
+func (b *Builder[T]) Configs() (map[State]StateHandler[T], map[string]TransitionProvider[T]) {
+	configs := make(map[State]StateHandler[T], len(b.states))
+	for state, stateBuilder := range b.states {
+		configs[state] = stateBuilder.handler
+	}
+	commands := make(map[string]TransitionProvider[T], len(b.commands))
+	for command, commandHandler := range b.commands {
+		commands[command] = commandHandler
+	}
+	return configs, commands
+}
+
+// Build creates FSM. Commands defined with the builder are passed as WithCommands option before the given options.
+// Like NewBotFsm, it panics on invalid configuration.
+func (b *Builder[T]) Build(bot *tgbotapi.BotAPI, optFns ...BotFsmOptsFn[T]) *BotFsm[T] {
+	configs, commands := b.Configs()
+	return NewBotFsm(bot, configs, append([]BotFsmOptsFn[T]{WithCommands(commands)}, optFns...)...)
+}
+
+// StateBuilder defines a single state. It also proxies Builder methods to keep the chain fluent.
+type StateBuilder[T any] struct {
+	builder *Builder[T]
+	handler *funcStateHandler[T]
+}
+
+// Message sets the state MessageFn. Messages with empty text aren't sent, and Validate warns about states without
+// message.
+func (s *StateBuilder[T]) Message(fn MessageFn[T]) *StateBuilder[T] {
+	s.handler.messageFn = fn
+	return s
+}
+
+// Text sets the static state message text.
+func (s *StateBuilder[T]) Text(text string) *StateBuilder[T] {
+	return s.Message(func(ctx context.Context, data T) MessageConfig {
+		return TextMessageConfig(text)
+	})
+}
+
+// OnText handles messages with non-empty text.
+func (s *StateBuilder[T]) OnText(fn TransitionFn[T]) *StateBuilder[T] {
+	s.handler.textFn = fn
+	return s
+}
+
+// OnMessage handles any messages which are not handled by OnText.
+func (s *StateBuilder[T]) OnMessage(fn TransitionFn[T]) *StateBuilder[T] {
+	s.handler.messageHandlerFn = fn
+	return s
+}
+
+// OnSuccessfulPayment handles successful payment service messages. They are handled by OnMessage otherwise.
+func (s *StateBuilder[T]) OnSuccessfulPayment(fn TransitionFn[T]) *StateBuilder[T] {
+	s.handler.successfulPaymentFn = fn
+	return s
+}
+
+// OnWebAppData handles data sent by the web app. Web app data messages passed to HandleRawUpdate are not passed to
+// other handlers: if it's not set, they keep the bot in the same state and the state message is sent again.
+func (s *StateBuilder[T]) OnWebAppData(fn WebAppDataFn[T]) *StateBuilder[T] {
+	s.handler.webAppDataFn = fn
+	return s
+}
+
+// OnCallback handles callback queries whose data starts with prefix. Handlers are checked in the order they are
+// added.
+func (s *StateBuilder[T]) OnCallback(prefix string, fn TransitionFn[T]) *StateBuilder[T] {
+	s.handler.callbackRoutes = append(s.handler.callbackRoutes, callbackRoute[T]{prefix: prefix, transitionFn: fn})
+	return s
+}
+
+// OnUpdate handles any message or callback query which is not handled by other handlers. If it's not set, unhandled
+// updates keep the bot in the same state and the state message is sent again. Updates of other types are handled only
+// by OnUpdateType handlers.
+func (s *StateBuilder[T]) OnUpdate(fn TransitionFn[T]) *StateBuilder[T] {
+	s.handler.updateFn = fn
+	return s
+}
+
+// OnUpdateType handles updates of the given type, e.g. edited messages. See UpdateTypeHandler.
+func (s *StateBuilder[T]) OnUpdateType(updateType UpdateType, fn TransitionFn[T]) *StateBuilder[T] {
+	if s.handler.updateTypeHandlers == nil {
+		s.handler.updateTypeHandlers = make(map[UpdateType]TransitionProvider[T])
+	}
+	s.handler.updateTypeHandlers[updateType] = &funcCommandHandler[T]{transitionFn: fn}
+	return s
+}
+
+// OnEnter sets the state OnEnter hook.
+func (s *StateBuilder[T]) OnEnter(fn HookFn[T]) *StateBuilder[T] {
+	s.handler.onEnterFn = fn
+	return s
+}
+
+// OnExit sets the state OnExit hook.
+func (s *StateBuilder[T]) OnExit(fn HookFn[T]) *StateBuilder[T] {
+	s.handler.onExitFn = fn
+	return s
+}
+
+// Targets declares states this state may switch to. See TargetStatesProvider.
+func (s *StateBuilder[T]) Targets(states ...State) *StateBuilder[T] {
+	s.handler.targets = append(s.handler.targets, states...)
+	return s
+}
+
+// RemoveKeyboardBefore removes keyboard before bot enters the state. See RemoveKeyboardBeforeMarker.
+func (s *StateBuilder[T]) RemoveKeyboardBefore() *StateBuilder[T] {
+	s.handler.removeKeyboardBefore = true
+	return s
+}
+
+// RemoveKeyboardAfter removes keyboard after bot left the state. See RemoveKeyboardAfterMarker.
+func (s *StateBuilder[T]) RemoveKeyboardAfter() *StateBuilder[T] {
+	s.handler.removeKeyboardAfter = true
+	return s
+}
+
+func (s *StateBuilder[T]) State(state State) *StateBuilder[T] {
+	return s.builder.State(state)
+}
+
+func (s *StateBuilder[T]) Command(command string, fn TransitionFn[T]) *Builder[T] {
+	return s.builder.Command(command, fn)
+}
+
+func (s *StateBuilder[T]) CommandTo(command string, state State) *Builder[T] {
+	return s.builder.CommandTo(command, state)
+}
+
+func (s *StateBuilder[T]) Build(bot *tgbotapi.BotAPI, optFns ...BotFsmOptsFn[T]) *BotFsm[T] {
+	return s.builder.Build(bot, optFns...)
+}
+
+type callbackRoute[T any] struct {
+	prefix       string
+	transitionFn TransitionFn[T]
+}
+
+// funcStateHandler is a StateHandler created by StateBuilder.
+type funcStateHandler[T any] struct {
+	messageFn            MessageFn[T]
+	textFn               TransitionFn[T]
+	messageHandlerFn     TransitionFn[T]
+	callbackRoutes       []callbackRoute[T]
+	updateFn             TransitionFn[T]
+	updateTypeHandlers   map[UpdateType]TransitionProvider[T]
+	successfulPaymentFn  TransitionFn[T]
+	webAppDataFn         WebAppDataFn[T]
+	onEnterFn            HookFn[T]
+	onExitFn             HookFn[T]
+	targets              []State
+	removeKeyboardBefore bool
+	removeKeyboardAfter  bool
+}
+
+func (h *funcStateHandler[T]) MessageFn(ctx context.Context, data T) MessageConfig {
+	if h.messageFn == nil {
+		return MessageConfig{}
+	}
+	return h.messageFn(ctx, data)
+}
+
+func (h *funcStateHandler[T]) TransitionFn(ctx context.Context, update *tgbotapi.Update, data T) (Transition, T) {
+	if update.Message != nil {
+		if update.Message.SuccessfulPayment != nil && h.successfulPaymentFn != nil {
+			return h.successfulPaymentFn(ctx, update, data)
+		}
+		if update.Message.Text != "" && h.textFn != nil {
+			return h.textFn(ctx, update, data)
+		}
+		if h.messageHandlerFn != nil {
+			return h.messageHandlerFn(ctx, update, data)
+		}
+	}
+	if update.CallbackQuery != nil {
+		for _, route := range h.callbackRoutes {
+			if strings.HasPrefix(update.CallbackQuery.Data, route.prefix) {
+				return route.transitionFn(ctx, update, data)
+			}
+		}
+	}
+	if h.updateFn != nil {
+		return h.updateFn(ctx, update, data)
+	}
+	return Transition{}, data
+}
+
+func (h *funcStateHandler[T]) WebAppDataFn(ctx context.Context, webAppData WebAppData, data T) (Transition, T) {
+	if h.webAppDataFn == nil {
+		return Transition{}, data
+	}
+	return h.webAppDataFn(ctx, webAppData, data)
+}
+
+func (h *funcStateHandler[T]) UpdateTypeHandlers() map[UpdateType]TransitionProvider[T] {
+	return h.updateTypeHandlers
+}
+
+func (h *funcStateHandler[T]) OnEnter(ctx context.Context, data T) (T, error) {
+	if h.onEnterFn == nil {
+		return data, nil
+	}
+	return h.onEnterFn(ctx, data)
+}
+
+func (h *funcStateHandler[T]) OnExit(ctx context.Context, data T) (T, error) {
+	if h.onExitFn == nil {
+		return data, nil
+	}
+	return h.onExitFn(ctx, data)
+}
+
+func (h *funcStateHandler[T]) TargetStates() []State {
+	return h.targets
+}
+
+func (h *funcStateHandler[T]) RemoveKeyboardBefore() bool {
+	return h.removeKeyboardBefore
+}
+
+func (h *funcStateHandler[T]) RemoveKeyboardAfter() bool {
+	return h.removeKeyboardAfter
+}
+
+// funcCommandHandler is a TransitionProvider created by Builder for commands and update types.
+type funcCommandHandler[T any] struct {
+	transitionFn TransitionFn[T]
+	targets      []State
+}
+
+func (h *funcCommandHandler[T]) TransitionFn(ctx context.Context, update *tgbotapi.Update, data T) (Transition, T) {
+	return h.transitionFn(ctx, update, data)
+}
+
+func (h *funcCommandHandler[T]) TargetStates() []State {
+	return h.targets
+}
@@ -0,0 +1,30 @@
+package fsm
+
+import (
+	"context"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestStateWithoutMessage(t *testing.T) {
+	fake, bot := newFakeTelegram(t)
+	builder := New[int]()
+	builder.State(UndefinedState).Text("start").
+		OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+			return StateTransition("silent"), data
+		})
+	builder.State("silent")
+	botFsm := builder.Build(bot)
+
+	warnings := botFsm.Validate().Warnings()
+	if len(warnings) != 1 || warnings[0].Kind != MissingMessageProblem || warnings[0].State != "silent" {
+		t.Fatalf("expected missing message warning, got %v", warnings)
+	}
+	if err := botFsm.HandleUpdate(context.Background(), textUpdate(1, "hi")); err != nil {
+		t.Fatalf("update error: %s", err)
+	}
+	if texts := fake.sentTexts(); len(texts) != 0 {
+		t.Fatalf("expected nothing sent, got %v", texts)
+	}
+}
@@ -3,33 +3,35 @@ package fsm
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 type ChatState[T any] struct {
-	chatId int64
-	state  State
-	data   T
+	sessionKey SessionKey
+	state      State
+	data       T
+	meta       Meta[T]
 }
 
 type chatStatesStore[T any] struct {
 	mx sync.RWMutex
-	m  map[int64]*ChatState[T]
+	m  map[SessionKey]*ChatState[T]
 }
 
-func (s *chatStatesStore[T]) put(key int64, state *ChatState[T]) {
+func (s *chatStatesStore[T]) put(key SessionKey, state *ChatState[T]) {
 	s.mx.Lock()
 	defer s.mx.Unlock()
 	s.m[key] = state
 }
 
-func (s *chatStatesStore[T]) get(key int64) (*ChatState[T], bool) {
+func (s *chatStatesStore[T]) get(key SessionKey) (*ChatState[T], bool) {
 	s.mx.RLock()
 	defer s.mx.RUnlock()
 	val, ok := s.m[key]
 	return val, ok
 }
 
-func (s *chatStatesStore[T]) delete(key int64) { //nolint:unused // will be used later
+func (s *chatStatesStore[T]) delete(key SessionKey) {
 	s.mx.Lock()
 	defer s.mx.Unlock()
 	delete(s.m, key)
@@ -39,30 +41,112 @@ type pseudoPersistenceHandler[T any] struct {
 	*chatStatesStore[T]
 }
 
-func (h *pseudoPersistenceHandler[T]) LoadStateFn(ctx context.Context, chatId int64) (state State, data T, err error) {
-	if chatState, ok := h.get(chatId); ok {
+func (h *pseudoPersistenceHandler[T]) LoadStateFn(
+	ctx context.Context,
+	sessionKey SessionKey,
+) (state State, data T, err error) {
+	if chatState, ok := h.get(sessionKey); ok {
 		state = chatState.state
 		data = chatState.data
 	}
 	return state, data, nil
 }
 
-func (h *pseudoPersistenceHandler[T]) SaveStateFn(ctx context.Context, chatId int64, state State, data T) error {
+func (h *pseudoPersistenceHandler[T]) SaveStateFn(
+	ctx context.Context,
+	sessionKey SessionKey,
+	state State,
+	data T,
+) error {
 	chatState := &ChatState[T]{
-		chatId: chatId,
-		state:  state,
-		data:   data,
+		sessionKey: sessionKey,
+		state:      state,
+		data:       data,
 	}
-	h.put(chatId, chatState)
+	if prevChatState, ok := h.get(sessionKey); ok {
+		chatState.meta = prevChatState.meta
+	}
+	h.put(sessionKey, chatState)
 	return nil
 }
 
-func getDefaultOpts[T any]() botFsmOpts[T] {
+func (h *pseudoPersistenceHandler[T]) LoadMetaFn(ctx context.Context, sessionKey SessionKey) (meta Meta[T], err error) {
+	if chatState, ok := h.get(sessionKey); ok {
+		meta = chatState.meta
+	}
+	return meta, nil
+}
+
+func (h *pseudoPersistenceHandler[T]) SaveStateWithMetaFn(
+	ctx context.Context,
+	sessionKey SessionKey,
+	state State,
+	data T,
+	meta Meta[T],
+) error {
+	h.put(sessionKey, &ChatState[T]{
+		sessionKey: sessionKey,
+		state:      state,
+		data:       data,
+		meta:       meta,
+	})
+	return nil
+}
+
+// memoryMetaHandler keeps Meta in memory for PersistenceHandler which doesn't implement MetaPersistenceHandler. Empty
+// meta is not kept, so memory is used only by sessions having history or marked inactive.
+type memoryMetaHandler[T any] struct {
+	PersistenceHandler[T]
+	store *chatStatesStore[T]
+}
+
+func newMemoryMetaHandler[T any](handler PersistenceHandler[T]) *memoryMetaHandler[T] {
+	return &memoryMetaHandler[T]{
+		PersistenceHandler: handler,
+		store:              &chatStatesStore[T]{m: make(map[SessionKey]*ChatState[T])},
+	}
+}
+
+func (h *memoryMetaHandler[T]) LoadMetaFn(ctx context.Context, sessionKey SessionKey) (meta Meta[T], err error) {
+	if chatState, ok := h.store.get(sessionKey); ok {
+		meta = chatState.meta
+	}
+	return meta, nil
+}
+
+func (h *memoryMetaHandler[T]) SaveStateWithMetaFn(
+	ctx context.Context,
+	sessionKey SessionKey,
+	state State,
+	data T,
+	meta Meta[T],
+) error {
+	err := h.SaveStateFn(ctx, sessionKey, state, data)
+	if err != nil {
+		return err
+	}
+	if meta.empty() {
+		h.store.delete(sessionKey)
+	} else {
+		h.store.put(sessionKey, &ChatState[T]{sessionKey: sessionKey, meta: meta})
+	}
+	return nil
+}
+
+func newPseudoPersistenceHandler[T any]() *pseudoPersistenceHandler[T] {
 	store := &chatStatesStore[T]{
-		m: make(map[int64]*ChatState[T]),
+		m: make(map[SessionKey]*ChatState[T]),
 	}
+	return &pseudoPersistenceHandler[T]{store}
+}
+
+func getDefaultOpts[T any]() botFsmOpts[T] {
 	return botFsmOpts[T]{
-		PersistenceHandler:     &pseudoPersistenceHandler[T]{store},
+		PersistenceHandler:     newPseudoPersistenceHandler[T](),
 		removeKeyboardTempText: "Thinking...",
+		metrics:                noopMetrics{},
+		tracer:                 noopTracer{},
+		paymentTimeout:         8 * time.Second,
+		paymentErrorText:       "Payment can't be processed now. Please try again later.",
 	}
 }
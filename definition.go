@@ -0,0 +1,239 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// DefinitionFormat is a format of declarative bot definition.
+type DefinitionFormat string
+
+const (
+	JSONDefinitionFormat DefinitionFormat = "json"
+	YAMLDefinitionFormat DefinitionFormat = "yaml"
+)
+
+// Definition is a declarative bot definition. It's suitable for simple menu or FAQ bots, which don't process any data.
+type Definition struct {
+	States map[State]StateDefinition `json:"states" yaml:"states"`
+	// Map key is a command without "/" prefix, value is the state command switches bot to.
+	Commands map[string]State `json:"commands,omitempty" yaml:"commands,omitempty"`
+}
+
+// StateDefinition describes a single state of declarative bot definition.
+type StateDefinition struct {
+	Text      string `json:"text" yaml:"text"`
+	ParseMode string `json:"parse_mode,omitempty" yaml:"parse_mode,omitempty"`
+	// Reply keyboard rows. Pressed button text is matched against button Text.
+	Keyboard        [][]ButtonDefinition `json:"keyboard,omitempty" yaml:"keyboard,omitempty"`
+	OneTimeKeyboard bool                 `json:"one_time_keyboard,omitempty" yaml:"one_time_keyboard,omitempty"`
+	// Inline keyboard rows. Pressed button callback data is matched against button Data or State.
+	InlineKeyboard [][]ButtonDefinition `json:"inline_keyboard,omitempty" yaml:"inline_keyboard,omitempty"`
+	// Remove keyboard before bot enters the state.
+	RemoveKeyboard bool `json:"remove_keyboard,omitempty" yaml:"remove_keyboard,omitempty"`
+	// Reply on input which doesn't match any button. If it's empty, the state message is sent again.
+	FallbackText string `json:"fallback_text,omitempty" yaml:"fallback_text,omitempty"`
+}
+
+// ButtonDefinition describes a keyboard button.
+type ButtonDefinition struct {
+	Text string `json:"text" yaml:"text"`
+	// The state bot switches to when button is pressed.
+	State State `json:"state,omitempty" yaml:"state,omitempty"`
+	// Inline keyboard only. Opens URL instead of sending callback query.
+	URL string `json:"url,omitempty" yaml:"url,omitempty"`
+	// Inline keyboard only. Callback data. It's equal to State by default.
+	Data string `json:"data,omitempty" yaml:"data,omitempty"`
+}
+
+func (b ButtonDefinition) callbackData() string {
+	if b.Data != "" {
+		return b.Data
+	}
+	return b.State
+}
+
+// ParseDefinition decodes definition in the given format and checks its consistency.
+func ParseDefinition(data []byte, format DefinitionFormat) (*Definition, error) {
+	definition := &Definition{}
+	var err error
+	switch format {
+	case JSONDefinitionFormat:
+		err = json.Unmarshal(data, definition)
+	case YAMLDefinitionFormat:
+		err = yaml.Unmarshal(data, definition)
+	default:
+		return nil, fmt.Errorf("unknown definition format %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode definition: %w", err)
+	}
+	err = definition.check()
+	if err != nil {
+		return nil, err
+	}
+	return definition, nil
+}
+
+// LoadDefinitionFile reads definition from file. Format is chosen by file extension: .json, .yaml or .yml.
+func LoadDefinitionFile(path string) (*Definition, error) {
+	var format DefinitionFormat
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		format = JSONDefinitionFormat
+	case ".yaml", ".yml":
+		format = YAMLDefinitionFormat
+	default:
+		return nil, fmt.Errorf("cannot detect definition format of %s", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDefinition(data, format)
+}
+
+func (d *Definition) check() error {
+	for state, stateDefinition := range d.States {
+		if stateDefinition.Text == "" {
+			return fmt.Errorf("state %s: text is required", state)
+		}
+		for _, row := range stateDefinition.Keyboard {
+			for _, button := range row {
+				if button.Text == "" || button.URL != "" || button.Data != "" {
+					return fmt.Errorf("state %s: reply keyboard button must have text only and optional state", state)
+				}
+			}
+		}
+		for _, row := range stateDefinition.InlineKeyboard {
+			for _, button := range row {
+				if button.Text == "" || (button.URL == "") == (button.callbackData() == "") {
+					return fmt.Errorf("state %s: inline keyboard button must have text and either url or state/data", state)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// DefinitionConfigs creates states and commands configuration from definition. Go handlers take precedence over
+// definition states with the same name, so a definition can be mixed with Go code.
+func DefinitionConfigs[T any](
+	definition *Definition,
+	handlers map[State]StateHandler[T],
+) (map[State]StateHandler[T], map[string]TransitionProvider[T]) {
+	configs := make(map[State]StateHandler[T], len(definition.States)+len(handlers))
+	for state, stateDefinition := range definition.States {
+		configs[state] = &definitionStateHandler[T]{stateDefinition}
+	}
+	for state, handler := range handlers {
+		configs[state] = handler
+	}
+	commands := make(map[string]TransitionProvider[T], len(definition.Commands))
+	for command, state := range definition.Commands {
+		commands[command] = &definitionCommandHandler[T]{state}
+	}
+	return configs, commands
+}
+
+// definitionStateHandler is a StateHandler created from StateDefinition.
+type definitionStateHandler[T any] struct {
+	StateDefinition
+}
+
+func (h *definitionStateHandler[T]) MessageFn(ctx context.Context, data T) MessageConfig {
+	messageConfig := TextMessageConfig(h.Text)
+	messageConfig.ParseMode = h.ParseMode
+	switch {
+	case len(h.InlineKeyboard) > 0:
+		rows := make([][]tgbotapi.InlineKeyboardButton, len(h.InlineKeyboard))
+		for i, row := range h.InlineKeyboard {
+			for _, button := range row {
+				if button.URL != "" {
+					rows[i] = append(rows[i], tgbotapi.NewInlineKeyboardButtonURL(button.Text, button.URL))
+				} else {
+					rows[i] = append(rows[i], tgbotapi.NewInlineKeyboardButtonData(button.Text, button.callbackData()))
+				}
+			}
+		}
+		messageConfig.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	case len(h.Keyboard) > 0:
+		rows := make([][]tgbotapi.KeyboardButton, len(h.Keyboard))
+		for i, row := range h.Keyboard {
+			for _, button := range row {
+				rows[i] = append(rows[i], tgbotapi.NewKeyboardButton(button.Text))
+			}
+		}
+		keyboard := tgbotapi.NewReplyKeyboard(rows...)
+		keyboard.OneTimeKeyboard = h.OneTimeKeyboard
+		messageConfig.ReplyMarkup = keyboard
+	}
+	return messageConfig
+}
+
+func (h *definitionStateHandler[T]) TransitionFn(ctx context.Context, update *tgbotapi.Update, data T) (Transition, T) {
+	if update.Message != nil {
+		for _, row := range h.Keyboard {
+			for _, button := range row {
+				if button.Text == update.Message.Text && button.State != "" {
+					return StateTransition(button.State), data
+				}
+			}
+		}
+	}
+	if update.CallbackQuery != nil {
+		for _, row := range h.InlineKeyboard {
+			for _, button := range row {
+				if button.URL == "" && button.callbackData() == update.CallbackQuery.Data && button.State != "" {
+					return StateTransition(button.State), data
+				}
+			}
+		}
+	}
+	if h.FallbackText != "" {
+		return TextTransition(h.FallbackText), data
+	}
+	return Transition{}, data
+}
+
+func (h *definitionStateHandler[T]) TargetStates() []State {
+	var states []State
+	for _, rows := range [][][]ButtonDefinition{h.Keyboard, h.InlineKeyboard} {
+		for _, row := range rows {
+			for _, button := range row {
+				if button.State != "" {
+					states = append(states, button.State)
+				}
+			}
+		}
+	}
+	return states
+}
+
+func (h *definitionStateHandler[T]) RemoveKeyboardBefore() bool {
+	return h.RemoveKeyboard
+}
+
+// definitionCommandHandler is a command TransitionProvider created from Definition.
+type definitionCommandHandler[T any] struct {
+	state State
+}
+
+func (h *definitionCommandHandler[T]) TransitionFn(
+	ctx context.Context,
+	update *tgbotapi.Update,
+	data T,
+) (Transition, T) {
+	return StateTransition(h.state), data
+}
+
+func (h *definitionCommandHandler[T]) TargetStates() []State {
+	return []State{h.state}
+}
@@ -0,0 +1,170 @@
+package fsm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const yamlDefinition = `
+states:
+  undefined:
+    text: Hello
+    keyboard:
+      - - text: Help
+          state: help
+        - text: Contacts
+          state: contacts
+    fallback_text: Use the keyboard
+  help:
+    text: Help
+    inline_keyboard:
+      - - text: Back
+          state: undefined
+        - text: Site
+          url: https://example.com
+      - - text: Again
+          data: again
+          state: help
+  contacts:
+    text: Contacts
+commands:
+  help: help
+`
+
+const jsonDefinition = `{
+  "states": {
+    "undefined": {
+      "text": "Hello",
+      "keyboard": [[{"text": "Help", "state": "help"}, {"text": "Contacts", "state": "contacts"}]],
+      "fallback_text": "Use the keyboard"
+    },
+    "help": {
+      "text": "Help",
+      "inline_keyboard": [
+        [{"text": "Back", "state": "undefined"}, {"text": "Site", "url": "https://example.com"}],
+        [{"text": "Again", "data": "again", "state": "help"}]
+      ]
+    },
+    "contacts": {"text": "Contacts"}
+  },
+  "commands": {"help": "help"}
+}`
+
+func TestParseDefinition(t *testing.T) {
+	fromYAML, err := ParseDefinition([]byte(yamlDefinition), YAMLDefinitionFormat)
+	if err != nil {
+		t.Fatalf("yaml error: %s", err)
+	}
+	fromJSON, err := ParseDefinition([]byte(jsonDefinition), JSONDefinitionFormat)
+	if err != nil {
+		t.Fatalf("json error: %s", err)
+	}
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Fatalf("yaml and json definitions differ: %+v, %+v", fromYAML, fromJSON)
+	}
+	if len(fromYAML.States) != 3 || fromYAML.Commands["help"] != "help" ||
+		fromYAML.States["help"].InlineKeyboard[1][0].callbackData() != "again" {
+		t.Fatalf("unexpected definition: %+v", fromYAML)
+	}
+	if _, err = ParseDefinition([]byte(jsonDefinition), "xml"); err == nil {
+		t.Fatalf("expected unknown format error")
+	}
+}
+
+func TestDefinitionCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		state StateDefinition
+		valid bool
+	}{
+		{"text only", StateDefinition{Text: "text"}, true},
+		{"missing text", StateDefinition{}, false},
+		{"reply button", StateDefinition{Text: "text", Keyboard: [][]ButtonDefinition{{{Text: "a"}}}}, true},
+		{"reply button without text", StateDefinition{Text: "text",
+			Keyboard: [][]ButtonDefinition{{{State: "a"}}}}, false},
+		{"reply button with url", StateDefinition{Text: "text",
+			Keyboard: [][]ButtonDefinition{{{Text: "a", URL: "https://example.com"}}}}, false},
+		{"reply button with data", StateDefinition{Text: "text",
+			Keyboard: [][]ButtonDefinition{{{Text: "a", Data: "a"}}}}, false},
+		{"inline button with state", StateDefinition{Text: "text",
+			InlineKeyboard: [][]ButtonDefinition{{{Text: "a", State: "a"}}}}, true},
+		{"inline button with url", StateDefinition{Text: "text",
+			InlineKeyboard: [][]ButtonDefinition{{{Text: "a", URL: "https://example.com"}}}}, true},
+		{"inline button without target", StateDefinition{Text: "text",
+			InlineKeyboard: [][]ButtonDefinition{{{Text: "a"}}}}, false},
+		{"inline button with url and data", StateDefinition{Text: "text",
+			InlineKeyboard: [][]ButtonDefinition{{{Text: "a", URL: "https://example.com", Data: "a"}}}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			definition := &Definition{States: map[State]StateDefinition{UndefinedState: test.state}}
+			if err := definition.check(); (err == nil) != test.valid {
+				t.Fatalf("expected valid %t, got %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func callbackUpdate(chatId int64, data string) *tgbotapi.Update {
+	return &tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   "1",
+		From: &tgbotapi.User{ID: chatId},
+		Message: &tgbotapi.Message{
+			MessageID: 1,
+			Chat:      &tgbotapi.Chat{ID: chatId, Type: "private"},
+		},
+		Data: data,
+	}}
+}
+
+func TestDefinitionBot(t *testing.T) {
+	definition, err := ParseDefinition([]byte(yamlDefinition), YAMLDefinitionFormat)
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+	handlers := map[State]StateHandler[int]{
+		"contacts": &funcStateHandler[int]{messageFn: func(ctx context.Context, data int) MessageConfig {
+			return TextMessageConfig("Go contacts")
+		}},
+	}
+	tests := []struct {
+		name     string
+		state    State
+		update   *tgbotapi.Update
+		newState State
+		text     string
+	}{
+		{"reply button", UndefinedState, textUpdate(1, "Help"), "help", "Help"},
+		{"go handler overrides definition", UndefinedState, textUpdate(1, "Contacts"), "contacts", "Go contacts"},
+		{"fallback text", UndefinedState, textUpdate(1, "what?"), UndefinedState, "Use the keyboard"},
+		{"inline button", "help", callbackUpdate(1, "undefined"), UndefinedState, "Hello"},
+		{"inline button with data", "help", callbackUpdate(1, "again"), "help", "Help"},
+		{"no fallback text", "help", textUpdate(1, "what?"), "help", "Help"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, bot := newFakeTelegram(t)
+			configs, commands := DefinitionConfigs[int](definition, handlers)
+			botFsm := NewBotFsm(bot, configs, WithCommands[int](commands))
+			ctx := context.Background()
+			if err := botFsm.saveState(ctx, SessionKey{ChatId: 1}, test.state, 0, Meta[int]{}); err != nil {
+				t.Fatalf("save error: %s", err)
+			}
+
+			if err := botFsm.HandleUpdate(ctx, test.update); err != nil {
+				t.Fatalf("update error: %s", err)
+			}
+			state, _, _, err := botFsm.loadState(ctx, SessionKey{ChatId: 1})
+			if err != nil {
+				t.Fatalf("load error: %s", err)
+			}
+			texts := fake.sentTexts()
+			if state != test.newState || len(texts) != 1 || texts[0] != test.text {
+				t.Fatalf("expected state %s and message %s, got %s and %v", test.newState, test.text, state, texts)
+			}
+		})
+	}
+}
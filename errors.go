@@ -0,0 +1,190 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TransitionError Error wrapper for TransitionFnE error.
+type TransitionError struct {
+	// The state transition was performed from. It's UndefinedState for commands.
+	State
+	// Command name, if the transition was performed by a command.
+	Command string
+	Err     error
+}
+
+func (e *TransitionError) Error() string {
+	if e.Command != "" {
+		return fmt.Sprintf("command %s transition error: %s", e.Command, e.Err)
+	}
+	return fmt.Sprintf("state %s transition error: %s", e.State, e.Err)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return e.Err
+}
+
+// MessageError Error wrapper for MessageFnE error.
+type MessageError struct {
+	State
+	Err error
+}
+
+func (e *MessageError) Error() string {
+	return fmt.Sprintf("state %s message error: %s", e.State, e.Err)
+}
+
+func (e *MessageError) Unwrap() error {
+	return e.Err
+}
+
+// TransitionProviderE is an alternative to TransitionProvider which can fail. If TransitionFnE returns an error, the
+// transition is canceled: nothing is saved or sent.
+type TransitionProviderE[T any] interface {
+	TransitionFnE(ctx context.Context, update *tgbotapi.Update, data T) (Transition, T, error)
+}
+
+// MessageConfigProviderE is an alternative to MessageConfigProvider which can fail. If MessageFnE returns an error,
+// the transition is canceled: nothing is saved or sent.
+type MessageConfigProviderE[T any] interface {
+	MessageFnE(ctx context.Context, data T) (MessageConfig, error)
+}
+
+type StateHandlerE[T any] interface {
+	MessageConfigProviderE[T]
+	TransitionProviderE[T]
+}
+
+// ErrorHandlerFn is called for every error returned by HandleUpdate or GoTo. chatId is 0, if it's unknown.
+type ErrorHandlerFn func(ctx context.Context, chatId int64, err error)
+
+func WithErrorHandler[T any](errorHandler ErrorHandlerFn) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.errorHandler = errorHandler
+	}
+}
+
+// FromStateHandlerE converts StateHandlerE into StateHandler, so it can be used in the state configuration. Other
+// interfaces implemented by handler (hooks, keyboard markers, etc.) are respected as well.
+func FromStateHandlerE[T any](handler StateHandlerE[T]) StateHandler[T] {
+	return &stateHandlerE[T]{handler}
+}
+
+// FromTransitionProviderE converts TransitionProviderE into TransitionProvider, so it can be used as a command.
+func FromTransitionProviderE[T any](handler TransitionProviderE[T]) TransitionProvider[T] {
+	return &transitionProviderE[T]{handler}
+}
+
+type stateHandlerE[T any] struct {
+	StateHandlerE[T]
+}
+
+// MessageFn is never called by FSM, since MessageFnE is preferred.
+func (h *stateHandlerE[T]) MessageFn(ctx context.Context, data T) MessageConfig {
+	messageConfig, _ := h.MessageFnE(ctx, data)
+	return messageConfig
+}
+
+// TransitionFn is never called by FSM, since TransitionFnE is preferred.
+func (h *stateHandlerE[T]) TransitionFn(ctx context.Context, update *tgbotapi.Update, data T) (Transition, T) {
+	transition, newData, _ := h.TransitionFnE(ctx, update, data)
+	return transition, newData
+}
+
+func (h *stateHandlerE[T]) Unwrap() any {
+	return h.StateHandlerE
+}
+
+type transitionProviderE[T any] struct {
+	TransitionProviderE[T]
+}
+
+// TransitionFn is never called by FSM, since TransitionFnE is preferred.
+func (h *transitionProviderE[T]) TransitionFn(ctx context.Context, update *tgbotapi.Update, data T) (Transition, T) {
+	transition, newData, _ := h.TransitionFnE(ctx, update, data)
+	return transition, newData
+}
+
+func (h *transitionProviderE[T]) Unwrap() any {
+	return h.TransitionProviderE
+}
+
+// handlerAs checks whether handler, or the handler wrapped by it, implements I.
+func handlerAs[I any](handler any) (I, bool) {
+	if i, ok := handler.(I); ok {
+		return i, true
+	}
+	if wrapper, ok := handler.(interface{ Unwrap() any }); ok {
+		return handlerAs[I](wrapper.Unwrap())
+	}
+	var empty I
+	return empty, false
+}
+
+// transitionFn calls TransitionFnE, if it's implemented, or TransitionFn otherwise.
+func (b *BotFsm[T]) transitionFn(
+	ctx context.Context,
+	state State,
+	command string,
+	provider TransitionProvider[T],
+	update *tgbotapi.Update,
+	data T,
+) (transition Transition, newData T, err error) {
+	ctx, span := b.tracer.Start(ctx, "TransitionFn")
+	span.SetAttribute("state", state)
+	if command != "" {
+		span.SetAttribute("command", command)
+	}
+	defer func() {
+		endSpan(span, err)
+	}()
+	providerE, ok := provider.(TransitionProviderE[T])
+	if !ok {
+		transition, newData = provider.TransitionFn(ctx, update, data)
+		return transition, newData, nil
+	}
+	transition, newData, err = providerE.TransitionFnE(ctx, update, data)
+	if err != nil {
+		return transition, newData, &TransitionError{State: state, Command: command, Err: err}
+	}
+	return transition, newData, nil
+}
+
+// messageFn calls MessageFnE, if it's implemented, or MessageFn otherwise.
+func (b *BotFsm[T]) messageFn(
+	ctx context.Context,
+	state State,
+	provider MessageConfigProvider[T],
+	data T,
+) (messageConfig MessageConfig, err error) {
+	ctx, span := b.tracer.Start(ctx, "MessageFn")
+	span.SetAttribute("state", state)
+	defer func() {
+		endSpan(span, err)
+	}()
+	providerE, ok := provider.(MessageConfigProviderE[T])
+	if !ok {
+		return provider.MessageFn(ctx, data), nil
+	}
+	messageConfig, err = providerE.MessageFnE(ctx, data)
+	if err != nil {
+		return messageConfig, &MessageError{State: state, Err: err}
+	}
+	return messageConfig, nil
+}
+
+// handleError logs error and passes it to the global error handler, if it exists.
+func (b *BotFsm[T]) handleError(ctx context.Context, logger fsmLogger, chatId int64, err *error) {
+	if *err == nil {
+		return
+	}
+	logger.log(ctx, slog.LevelError, "update processing failed", slog.String("error", (*err).Error()))
+	b.metrics.ErrorOccurred(getErrorType(*err))
+	if b.errorHandler != nil {
+		b.errorHandler(ctx, chatId, *err)
+	}
+}
@@ -0,0 +1,145 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var errHandler = errors.New("handler failed")
+
+func commandUpdate(chatId int64, command string) *tgbotapi.Update {
+	update := textUpdate(chatId, "/"+command)
+	update.Message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command) + 1}}
+	return update
+}
+
+// failingHandler implements StateHandlerE, EnterHandler and TargetStatesProvider.
+type failingHandler struct {
+	transitionErr error
+	messageErr    error
+	entered       int
+}
+
+func (h *failingHandler) MessageFnE(ctx context.Context, data int) (MessageConfig, error) {
+	return TextMessageConfig("message"), h.messageErr
+}
+
+func (h *failingHandler) TransitionFnE(
+	ctx context.Context,
+	update *tgbotapi.Update,
+	data int,
+) (Transition, int, error) {
+	return StateTransition("next"), data + 1, h.transitionErr
+}
+
+func (h *failingHandler) OnEnter(ctx context.Context, data int) (int, error) {
+	h.entered++
+	return data, nil
+}
+
+func (h *failingHandler) TargetStates() []State {
+	return []State{"next"}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		update        *tgbotapi.Update
+		transitionErr error
+		messageErr    error
+		commandErr    error
+		errTarget     any
+		check         func(err error) bool
+	}{
+		{"transition error", textUpdate(1, "hi"), errHandler, nil, nil, new(*TransitionError), func(err error) bool {
+			var transitionErr *TransitionError
+			return errors.As(err, &transitionErr) && transitionErr.State == UndefinedState
+		}},
+		{"message error", textUpdate(1, "hi"), nil, errHandler, nil, new(*MessageError), func(err error) bool {
+			var messageErr *MessageError
+			return errors.As(err, &messageErr) && messageErr.State == "next"
+		}},
+		{"command transition error", commandUpdate(1, "fail"), nil, nil, errHandler, new(*TransitionError),
+			func(err error) bool {
+				var transitionErr *TransitionError
+				return errors.As(err, &transitionErr) && transitionErr.Command == "fail"
+			}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, bot := newFakeTelegram(t)
+			configs := map[State]StateHandler[int]{
+				UndefinedState: FromStateHandlerE[int](&failingHandler{transitionErr: test.transitionErr}),
+				"next":         FromStateHandlerE[int](&failingHandler{messageErr: test.messageErr}),
+			}
+			commands := map[string]TransitionProvider[int]{
+				"fail": FromTransitionProviderE[int](&failingHandler{transitionErr: test.commandErr}),
+			}
+			var handledChatId int64
+			var handledErr error
+			botFsm := NewBotFsm(bot, configs, WithCommands[int](commands),
+				WithErrorHandler[int](func(ctx context.Context, chatId int64, err error) {
+					handledChatId, handledErr = chatId, err
+				}))
+			ctx := context.Background()
+
+			err := botFsm.HandleUpdate(ctx, test.update)
+			if !errors.As(err, test.errTarget) || !errors.Is(err, errHandler) || !test.check(err) {
+				t.Fatalf("expected %T, got %v", test.errTarget, err)
+			}
+			if handledChatId != 1 || handledErr != err {
+				t.Fatalf("expected error handler called for chat 1 with %v, got %d and %v", err, handledChatId, handledErr)
+			}
+			if texts := fake.sentTexts(); len(texts) != 0 {
+				t.Fatalf("expected nothing sent, got %v", texts)
+			}
+			state, data, _, err := botFsm.loadState(ctx, SessionKey{ChatId: 1})
+			if err != nil {
+				t.Fatalf("load error: %s", err)
+			}
+			if state != UndefinedState || data != 0 {
+				t.Fatalf("expected nothing saved, got state %s and data %d", state, data)
+			}
+		})
+	}
+}
+
+func TestStateHandlerEWrapper(t *testing.T) {
+	fake, bot := newFakeTelegram(t)
+	next := &failingHandler{}
+	configs := map[State]StateHandler[int]{
+		UndefinedState: FromStateHandlerE[int](&failingHandler{}),
+		"next":         FromStateHandlerE[int](next),
+	}
+	commands := map[string]TransitionProvider[int]{
+		"next": FromTransitionProviderE[int](&failingHandler{}),
+	}
+	botFsm := NewBotFsm(bot, configs, WithCommands[int](commands))
+
+	if err := botFsm.HandleUpdate(context.Background(), textUpdate(1, "hi")); err != nil {
+		t.Fatalf("update error: %s", err)
+	}
+	if next.entered != 1 {
+		t.Fatalf("expected OnEnter of the wrapped handler called once, got %d", next.entered)
+	}
+	if texts := fake.sentTexts(); len(texts) != 1 || texts[0] != "message" {
+		t.Fatalf("unexpected messages: %v", texts)
+	}
+	edges := botFsm.Graph().Edges
+	expected := []GraphEdge{
+		{From: AnyState, To: "next", Label: "/next"},
+		{From: "next", To: "next"},
+		{From: UndefinedState, To: "next"},
+	}
+	if len(edges) != len(expected) {
+		t.Fatalf("expected edges %v, got %v", expected, edges)
+	}
+	for i := range expected {
+		if edges[i] != expected[i] {
+			t.Fatalf("expected edges %v, got %v", expected, edges)
+		}
+	}
+}
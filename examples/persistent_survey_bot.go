@@ -35,24 +35,27 @@ type WhoamiCommandHandler struct {
 	File string
 }
 
-func (h WhoamiCommandHandler) TransitionFn(ctx context.Context, update *tgbotapi.Update, data Data) (fsm.Transition, Data) {
-	// "/whoami" command returns information about yourself, if it exists and non-empty.
+func (h WhoamiCommandHandler) TransitionFnE(ctx context.Context, update *tgbotapi.Update, data Data) (fsm.Transition, Data, error) {
+	// "/whoami" command returns information about yourself, if it exists and non-empty. I/O errors are passed to the
+	// global error handler, and the chat state is not changed in that case.
 	file, err := os.Open(h.File)
 	if err != nil {
-		return fsm.TextTransition("Error in attempt to load data"), Data{}
+		return fsm.Transition{}, data, err
 	}
+	defer file.Close()
 	r := csv.NewReader(file)
 	records, err := r.ReadAll()
 	if err != nil {
-		return fsm.TextTransition("Error in attempt to load data"), Data{}
+		return fsm.Transition{}, data, err
 	}
-	chatIdStr := strconv.FormatInt(update.Message.Chat.ID, 10)
+	// The bot uses default per chat session strategy.
+	sessionKeyStr := fsm.SessionKey{ChatId: update.Message.Chat.ID}.String()
 	for _, record := range records {
-		if record[0] == chatIdStr && record[2] != "" && record[3] != "0" {
-			return fsm.TextTransition(fmt.Sprintf("I'm %s %s years old", record[2], record[3])), data
+		if record[0] == sessionKeyStr && record[2] != "" && record[3] != "0" {
+			return fsm.TextTransition(fmt.Sprintf("I'm %s %s years old", record[2], record[3])), data, nil
 		}
 	}
-	return fsm.TextTransition("You have to complete survey about yourself first"), data
+	return fsm.TextTransition("You have to complete survey about yourself first"), data, nil
 }
 
 type NameStateHandler struct{}
@@ -106,7 +109,7 @@ type CsvFilePersistenceHandler struct {
 	File string
 }
 
-func (h CsvFilePersistenceHandler) LoadStateFn(ctx context.Context, chatId int64) (state fsm.State, data Data, err error) {
+func (h CsvFilePersistenceHandler) LoadStateFn(ctx context.Context, sessionKey fsm.SessionKey) (state fsm.State, data Data, err error) {
 	file, err := os.Open(h.File)
 	if err != nil {
 		return "", Data{}, err
@@ -116,9 +119,9 @@ func (h CsvFilePersistenceHandler) LoadStateFn(ctx context.Context, chatId int64
 	if err != nil {
 		return "", Data{}, err
 	}
-	chatIdStr := strconv.FormatInt(chatId, 10)
+	sessionKeyStr := sessionKey.String()
 	for _, record := range records {
-		if record[0] == chatIdStr {
+		if record[0] == sessionKeyStr {
 			age, err := strconv.Atoi(record[3])
 			if err != nil {
 				return "", Data{}, err
@@ -129,7 +132,7 @@ func (h CsvFilePersistenceHandler) LoadStateFn(ctx context.Context, chatId int64
 	return "", Data{}, nil
 }
 
-func (h CsvFilePersistenceHandler) SaveStateFn(ctx context.Context, chatId int64, state fsm.State, data Data) error {
+func (h CsvFilePersistenceHandler) SaveStateFn(ctx context.Context, sessionKey fsm.SessionKey, state fsm.State, data Data) error {
 	file, err := os.Create(h.File)
 	if err != nil {
 		return err
@@ -139,14 +142,14 @@ func (h CsvFilePersistenceHandler) SaveStateFn(ctx context.Context, chatId int64
 	if err != nil {
 		return err
 	}
-	chatIdStr := strconv.FormatInt(chatId, 10)
+	sessionKeyStr := sessionKey.String()
 	rowIndex := -1
 	for index, record := range records {
-		if record[0] == chatIdStr {
+		if record[0] == sessionKeyStr {
 			rowIndex = index
 		}
 	}
-	row := []string{chatIdStr, state, data.PersonName, strconv.Itoa(data.PersonAge)}
+	row := []string{sessionKeyStr, state, data.PersonName, strconv.Itoa(data.PersonAge)}
 	if rowIndex == -1 {
 		rowIndex = len(records)
 		records = append(records, row)
@@ -183,13 +186,20 @@ func main() {
 
 	commands := make(map[string]fsm.TransitionProvider[Data])
 	commands["start"] = StartCommandHandler{}
-	commands["whoami"] = WhoamiCommandHandler{File: File}
+	// WhoamiCommandHandler implements error-returning TransitionFnE, so it must be converted.
+	commands["whoami"] = fsm.FromTransitionProviderE[Data](WhoamiCommandHandler{File: File})
 
 	botFsm := fsm.NewBotFsm(
 		bot,
 		configs,
 		fsm.WithCommands[Data](commands),
 		fsm.WithPersistenceHandler[Data](CsvFilePersistenceHandler{File: File}),
+		fsm.WithErrorHandler[Data](func(ctx context.Context, chatId int64, err error) {
+			log.Println(err)
+			if chatId != 0 {
+				_, _ = bot.Send(tgbotapi.NewMessage(chatId, "Something went wrong, please try again later"))
+			}
+		}),
 	)
 
 	ctx := context.TODO()
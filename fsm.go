@@ -3,14 +3,17 @@ package fsm
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"strings"
+	"sync"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 const UndefinedState = "undefined"
 
-// NoChatIdError Returned when FSM was not able to get chat id either from Message or CallbackQuery.
+// NoChatIdError Returned when update doesn't belong to any chat and there is no global handler for its type.
 type NoChatIdError struct {
 	*tgbotapi.Update
 }
@@ -88,6 +91,40 @@ type botFsmOpts[T any] struct {
 	// This message will be sent along with RemoveKeyboard request. It will be removed right after that. But user
 	// might see this message for a second.
 	removeKeyboardTempText string
+	// Allowed transitions. Any transition is allowed, if it's empty.
+	transitionRules []TransitionRule[T]
+	// Observers notified about every saved transition.
+	transitionObservers []TransitionObserver
+	// Maximum number of history entries kept per chat. History is disabled when it's 0.
+	historySize int
+	// The state used when the loaded one doesn't exist.
+	missingStateFallback State
+	// Bot reaction on panic during update processing.
+	panicMessageConfigProvider MessageConfigProvider[T]
+	panicState                 State
+	// Global error handler.
+	errorHandler ErrorHandlerFn
+	// Structured logger. Logging is disabled by default.
+	logger fsmLogger
+	// Measurements receiver. Measurements are discarded by default.
+	metrics Metrics
+	tracer  Tracer
+	// Receives an event per processed update.
+	auditSink AuditSink
+	// Defines how updates are grouped into sessions.
+	sessionStrategy SessionStrategy
+	// Handlers of updates without chat.
+	globalHandlers            map[UpdateType]GlobalHandlerFn
+	inlineQueryHandler        InlineQueryHandler[T]
+	chosenInlineResultHandler ChosenInlineResultHandler[T]
+	// Hooks called when the bot membership in the chat changes.
+	lifecycleHooks LifecycleHooks
+	// Payments settings.
+	preCheckoutQueryHandler PreCheckoutQueryHandler[T]
+	shippingQueryHandler    ShippingQueryHandler[T]
+	paymentProviderToken    string
+	paymentTimeout          time.Duration
+	paymentErrorText        string
 }
 
 type BotFsmOptsFn[T any] func(options *botFsmOpts[T])
@@ -116,12 +153,25 @@ func WithRemoveKeyboardTempText[T any](text string) BotFsmOptsFn[T] {
 	}
 }
 
+// sender sends requests to Telegram. It's implemented by tgbotapi.BotAPI.
+type sender interface {
+	Send(chattable tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(chattable tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+}
+
 type BotFsm[T any] struct {
-	bot     *tgbotapi.BotAPI
-	configs map[State]StateHandler[T]
+	bot sender
+	// Guards configs and commands, which can be replaced by Reload. It's held only to take a snapshot, see snapshot.
+	configsMx sync.RWMutex
+	configs   map[State]StateHandler[T]
 	botFsmOpts[T]
+	metaHandler MetaPersistenceHandler[T]
+	middlewares []Middleware[T]
 }
 
+// NewBotFsm creates FSM. It panics if UndefinedState configuration is missing or empty state configuration is
+// provided. Use TryNewBotFsm to get an error and complete configuration validation instead.
 func NewBotFsm[T any](bot *tgbotapi.BotAPI, configs map[string]StateHandler[T], optFns ...BotFsmOptsFn[T]) *BotFsm[T] {
 	if _, ok := configs[UndefinedState]; !ok {
 		panic("undefined state configuration must be provided")
@@ -130,33 +180,111 @@ func NewBotFsm[T any](bot *tgbotapi.BotAPI, configs map[string]StateHandler[T],
 		panic("empty state configuration forbidden")
 	}
 
+	return newBotFsm(bot, configs, optFns...)
+}
+
+func newBotFsm[T any](bot *tgbotapi.BotAPI, configs map[string]StateHandler[T], optFns ...BotFsmOptsFn[T]) *BotFsm[T] {
 	opts := getDefaultOpts[T]()
 	for _, optFn := range optFns {
 		optFn(&opts)
 	}
 
+	metaHandler, ok := opts.PersistenceHandler.(MetaPersistenceHandler[T])
+	if !ok {
+		metaHandler = newMemoryMetaHandler(opts.PersistenceHandler)
+	}
+
 	return &BotFsm[T]{
-		bot:        bot,
-		configs:    configs,
-		botFsmOpts: opts,
+		bot:         bot,
+		configs:     configs,
+		botFsmOpts:  opts,
+		metaHandler: metaHandler,
 	}
 }
 
 // HandleUpdate processes tgbotapi Update and handle it according to given FSM config.
 func (b *BotFsm[T]) HandleUpdate(ctx context.Context, update *tgbotapi.Update) error {
+	return b.handleUpdateWithExtras(ctx, update, updateExtras{})
+}
+
+func (b *BotFsm[T]) handleUpdateWithExtras(ctx context.Context, update *tgbotapi.Update, extras updateExtras) error {
+	return b.snapshot().processUpdate(ctx, update, extras)
+}
+
+// processUpdate handles the update. It must be called on a snapshot.
+func (b *BotFsm[T]) processUpdate(ctx context.Context, update *tgbotapi.Update, extras updateExtras) (err error) {
 	chatId := getChatId(update)
+	ctx, span := b.tracer.Start(ctx, "HandleUpdate")
+	span.SetAttribute("chat_id", chatId)
+	span.SetAttribute("update_id", update.UpdateID)
+	span.SetAttribute("update_type", string(getUpdateType(update)))
+	defer func() {
+		endSpan(span, err)
+	}()
+	logger := b.logger.with(slog.Int64("chat_id", chatId), slog.Int("update_id", update.UpdateID))
+	logger.log(ctx, slog.LevelDebug, "update received")
+	var sessionKey SessionKey
+	var state State
+	var data T
+	var updateContext *UpdateContext[T]
+	defer func(start time.Time) {
+		b.metrics.UpdateHandled(getUpdateType(update), state, time.Since(start))
+		b.audit(ctx, logger, update, updateContext, err)
+	}(time.Now())
+	defer b.handleError(ctx, logger, chatId, &err)
+	defer func() {
+		if value := recover(); value != nil {
+			err = b.handlePanic(ctx, sessionKey, chatId, extras.threadId, data, value)
+		}
+	}()
 	if chatId == 0 {
-		return &NoChatIdError{update}
+		return b.handleChatlessUpdate(ctx, logger, update)
+	}
+	sessionKey, err = b.getSessionKey(update, chatId, extras)
+	if err != nil {
+		return err
 	}
 
-	state, data, err := b.resumeState(ctx, update)
+	state, data, meta, err := b.loadState(ctx, sessionKey)
 	if err != nil {
 		return err
 	}
+	logger.log(ctx, slog.LevelDebug, "state loaded", slog.String("state", state))
+	if update.MyChatMember != nil {
+		meta, err = b.handleChatMemberUpdate(ctx, logger, sessionKey, update, state, data, meta)
+	} else {
+		meta, err = b.reactivateSession(ctx, logger, sessionKey, chatId, update, state, data, meta)
+	}
+	if err != nil {
+		return err
+	}
+
+	updateContext = &UpdateContext[T]{
+		Update:     update,
+		ChatId:     chatId,
+		ThreadId:   extras.threadId,
+		WebAppData: extras.webAppData,
+		SessionKey: sessionKey,
+		State:      state,
+		Data:       data,
+		Command:    extractCommand(update),
+		meta:       meta,
+		logger:     logger,
+	}
+	err = b.chain(b.handleUpdate)(ctx, updateContext)
+	return b.handleSendError(ctx, logger, sessionKey, chatId, err)
+}
 
-	command := extractCommand(update)
+// handleUpdate is the innermost Handler performing the transition for resumed state.
+func (b *BotFsm[T]) handleUpdate(ctx context.Context, updateContext *UpdateContext[T]) error {
+	update, chatId, command := updateContext.Update, updateContext.ChatId, updateContext.Command
+	logger := updateContext.logger
+	loadedState, data, meta := updateContext.State, updateContext.Data, updateContext.meta
+	loadedStack := meta.Stack
+	state := loadedState
 	if command != "" {
 		state = UndefinedState
+		meta.Stack = nil
 	}
 
 	stateHandler, ok := b.configs[state]
@@ -165,140 +293,411 @@ func (b *BotFsm[T]) HandleUpdate(ctx context.Context, update *tgbotapi.Update) e
 	}
 
 	var transition Transition
+	var err error
 	newData := data
 	if command != "" {
 		commandTransitionHandler, ok := b.commands[command] //nolint:govet // it's ok
 		if ok {
-			transition, newData = commandTransitionHandler.TransitionFn(ctx, update, data)
+			logger.log(ctx, slog.LevelInfo, "command matched", slog.String("command", command))
+			b.metrics.CommandInvoked(command)
+			transition, newData, err = b.transitionFn(ctx, state, command, commandTransitionHandler, update, data)
 		} else {
+			logger.log(ctx, slog.LevelInfo, "unknown command", slog.String("command", command))
+			b.metrics.UnknownCommand(command)
 			transition = Transition{}
 		}
 	} else {
-		transition, newData = stateHandler.TransitionFn(ctx, update, data)
+		transitionProvider, ok := getTransitionProvider( //nolint:govet // it's ok
+			stateHandler,
+			update,
+			updateContext.WebAppData,
+		)
+		if !ok {
+			logger.log(ctx, slog.LevelDebug, "update ignored")
+			return nil
+		}
+		transition, newData, err = b.transitionFn(ctx, state, "", transitionProvider, update, data)
+	}
+	if err != nil {
+		return err
+	}
+
+	back := transition.kind == backTransitionKind
+	transition, newData, meta, err = b.resolveTransition(ctx, state, transition, newData, meta)
+	if err != nil {
+		return err
 	}
 
 	newState := transition.State
 	if newState == "" {
 		newState = state
 	}
+	if transition.checkFrom != "" {
+		err = b.checkTransition(ctx, transition.checkFrom, newState, newData)
+		if err != nil {
+			return err
+		}
+	}
+	if !back && newState != loadedState {
+		meta = b.recordHistory(meta, loadedState, data, loadedStack)
+	}
 
-	messageConfig := transition.MessageConfig
 	newStateHandler, ok := b.configs[newState]
 	if !ok {
 		return &NextStateConfigNotFoundError{newState}
 	}
+	newData, err = b.runHooks(ctx, loadedState, newState, newData)
+	if err != nil {
+		return err
+	}
+	updateContext.Transition, updateContext.NewState, updateContext.NewData = transition, newState, newData
+	logger.log(ctx, slog.LevelInfo, "transition chosen",
+		slog.String("state", loadedState), slog.String("new_state", newState))
+
+	messageConfig := transition.MessageConfig
 	if messageConfig.Empty() {
-		messageFn := newStateHandler.MessageFn
+		var messageConfigProvider MessageConfigProvider[T] = newStateHandler
 		if command != "" && transition.State == "" && b.unknownCommandMessageConfigProvider != nil {
 			// Command doesn't exist
-			messageFn = b.unknownCommandMessageConfigProvider.MessageFn
+			messageConfigProvider = b.unknownCommandMessageConfigProvider
+		}
+		messageConfig, err = b.messageFn(ctx, newState, messageConfigProvider, newData)
+		if err != nil {
+			return err
 		}
-		messageConfig = messageFn(ctx, newData)
 	}
 
-	removeKeyboardBeforeMarker, okBefore := newStateHandler.(RemoveKeyboardBeforeMarker)
-	removeKeyboardAfterMarker, okAfter := stateHandler.(RemoveKeyboardAfterMarker)
+	removeKeyboardBeforeMarker, okBefore := handlerAs[RemoveKeyboardBeforeMarker](newStateHandler)
+	removeKeyboardAfterMarker, okAfter := handlerAs[RemoveKeyboardAfterMarker](stateHandler)
 	if (okBefore && removeKeyboardBeforeMarker.RemoveKeyboardBefore()) ||
 		(okAfter && removeKeyboardAfterMarker.RemoveKeyboardAfter()) || messageConfig.RemoveKeyboard {
-		err = b.removeKeyboard(chatId)
+		err = b.removeKeyboard(ctx, chatId, updateContext.ThreadId)
 		if err != nil {
 			return err
 		}
+		logger.log(ctx, slog.LevelDebug, "keyboard removed")
 	}
 
-	err = b.SaveStateFn(ctx, chatId, newState, newData)
+	err = b.saveState(ctx, updateContext.SessionKey, newState, newData, meta)
 	if err != nil {
-		return fmt.Errorf("error in attempt to save a new state: %w", err)
+		return err
+	}
+	logger.log(ctx, slog.LevelDebug, "state saved", slog.String("new_state", newState))
+	b.notifyTransition(ctx, TransitionEvent{
+		ChatId:      chatId,
+		SessionKey:  updateContext.SessionKey,
+		From:        loadedState,
+		To:          newState,
+		TriggerKind: getTriggerKind(update, command),
+		Command:     command,
+	})
+
+	updateContext.messages = b.getStateMessageConfigs(chatId, messageConfig)
+	invoice := b.getInvoiceConfig(chatId, messageConfig)
+	return b.sendMessages(ctx, logger, updateContext.ThreadId, updateContext.messages, invoice)
+}
+
+// GoTo forces chat transition to a specific state. This function is useful when you need to trigger some notifications,
+// or start a new scenario. The session is derived from the chat id according to the session strategy; for group chats
+// under PerUserSession and PerChatUserSession strategies AmbiguousSessionError is returned, use GoToSession instead.
+// GoTo does nothing for inactive sessions, see LifecycleHooks. A regular transition to another state resets the
+// sub-flow call stack.
+func (b *BotFsm[T]) GoTo(ctx context.Context, chatId int64, transition Transition, data T) error {
+	sessionKey, err := b.getChatSessionKey(chatId, 0)
+	if err != nil {
+		return err
 	}
+	return b.goTo(ctx, sessionKey, chatId, 0, transition, data)
+}
+
+func (b *BotFsm[T]) goTo(
+	ctx context.Context,
+	sessionKey SessionKey,
+	chatId int64,
+	threadId int,
+	transition Transition,
+	data T,
+) error {
+	return b.snapshot().processGoTo(ctx, sessionKey, chatId, threadId, transition, data)
+}
+
+// processGoTo performs GoTo with error handling and panic recovery. It must be called on a snapshot.
+func (b *BotFsm[T]) processGoTo(
+	ctx context.Context,
+	sessionKey SessionKey,
+	chatId int64,
+	threadId int,
+	transition Transition,
+	data T,
+) (err error) {
+	ctx, span := b.tracer.Start(ctx, "GoTo")
+	span.SetAttribute("chat_id", chatId)
+	span.SetAttribute("new_state", transition.State)
+	defer func() {
+		endSpan(span, err)
+	}()
+	logger := b.logger.with(slog.Int64("chat_id", chatId))
+	logger.log(ctx, slog.LevelDebug, "goto requested", slog.String("new_state", transition.State))
+	defer b.handleError(ctx, logger, chatId, &err)
+	defer func() {
+		if value := recover(); value != nil {
+			err = b.handlePanic(ctx, sessionKey, chatId, threadId, data, value)
+		}
+	}()
+	return b.switchState(ctx, logger, sessionKey, chatId, threadId, transition, data)
+}
 
-	for _, msgConfig := range b.getStateMessageConfigs(chatId, messageConfig) {
-		_, err = b.bot.Send(msgConfig)
+// switchState performs GoTo transition. It must be called on a snapshot.
+func (b *BotFsm[T]) switchState(
+	ctx context.Context,
+	logger fsmLogger,
+	sessionKey SessionKey,
+	chatId int64,
+	threadId int,
+	transition Transition,
+	data T,
+) error {
+	state, loadedData, meta, err := b.loadState(ctx, sessionKey)
+	if err != nil {
+		return err
+	}
+	if meta.Inactive {
+		logger.log(ctx, slog.LevelInfo, "goto skipped for inactive session", slog.String("new_state", transition.State))
+		return nil
+	}
+	loadedStack := meta.Stack
+	if transition.kind == regularTransitionKind && transition.State != state {
+		// Forced switch to another state leaves sub-flows, like commands do.
+		meta.Stack = nil
+	}
+	back := transition.kind == backTransitionKind
+	transition, data, meta, err = b.resolveTransition(ctx, state, transition, data, meta)
+	if err != nil {
+		return err
+	}
+	if transition.checkFrom != "" {
+		err = b.checkTransition(ctx, transition.checkFrom, transition.State, data)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
-}
+	if !back && transition.State != state {
+		meta = b.recordHistory(meta, state, loadedData, loadedStack)
+	}
 
-// GoTo forces chat transition to a specific state. This function is useful when you need to trigger some notifications,
-// or start a new scenario.
-func (b *BotFsm[T]) GoTo(ctx context.Context, chatId int64, transition Transition, data T) error {
 	newStateConfig, ok := b.configs[transition.State]
 	if !ok {
 		return &NextStateConfigNotFoundError{transition.State}
 	}
+	data, err = b.runHooks(ctx, state, transition.State, data)
+	if err != nil {
+		return err
+	}
+
 	messageConfig := transition.MessageConfig
 	if messageConfig.Empty() {
-		messageConfig = newStateConfig.MessageFn(ctx, data)
+		messageConfig, err = b.messageFn(ctx, transition.State, newStateConfig, data)
+		if err != nil {
+			return err
+		}
 	}
 
-	err := b.SaveStateFn(ctx, chatId, transition.State, data)
+	err = b.saveState(ctx, sessionKey, transition.State, data, meta)
 	if err != nil {
-		return &SaveStateError{err}
+		return err
 	}
+	logger.log(ctx, slog.LevelInfo, "transition saved",
+		slog.String("state", state), slog.String("new_state", transition.State))
+	b.notifyTransition(ctx, TransitionEvent{
+		ChatId:      chatId,
+		SessionKey:  sessionKey,
+		From:        state,
+		To:          transition.State,
+		TriggerKind: GoToTrigger,
+	})
 
 	if messageConfig.RemoveKeyboard {
-		err = b.removeKeyboard(chatId)
+		err = b.removeKeyboard(ctx, chatId, threadId)
 		if err != nil {
-			return err
+			return b.handleSendError(ctx, logger, sessionKey, chatId, err)
 		}
+		logger.log(ctx, slog.LevelDebug, "keyboard removed")
 	}
 
-	for _, msgConfig := range b.getStateMessageConfigs(chatId, messageConfig) {
-		_, err = b.bot.Send(msgConfig)
+	msgConfigs, invoice := b.getStateMessageConfigs(chatId, messageConfig), b.getInvoiceConfig(chatId, messageConfig)
+	err = b.sendMessages(ctx, logger, threadId, msgConfigs, invoice)
+	return b.handleSendError(ctx, logger, sessionKey, chatId, err)
+}
+
+func (b *BotFsm[T]) sendMessages(
+	ctx context.Context,
+	logger fsmLogger,
+	threadId int,
+	msgConfigs []tgbotapi.MessageConfig,
+	invoice *tgbotapi.InvoiceConfig,
+) error {
+	for _, msgConfig := range msgConfigs {
+		_, err := b.send(ctx, msgConfig, threadId)
+		if err != nil {
+			return err
+		}
+	}
+	if invoice != nil {
+		_, err := b.send(ctx, *invoice, threadId)
 		if err != nil {
 			return err
 		}
 	}
+	logger.log(ctx, slog.LevelDebug, "messages sent", slog.Int("count", len(msgConfigs)))
 	return nil
 }
 
-func (b *BotFsm[T]) resumeState(ctx context.Context, update *tgbotapi.Update) (State, T, error) {
-	chatId := getChatId(update)
-
+func (b *BotFsm[T]) loadState(ctx context.Context, sessionKey SessionKey) (State, T, Meta[T], error) {
 	var emptyData T
-	if chatId == 0 {
-		return "", emptyData, &NoChatIdError{update}
-	}
-
-	state, data, err := b.LoadStateFn(ctx, chatId)
+	spanCtx, span := b.tracer.Start(ctx, "LoadStateFn")
+	start := time.Now()
+	state, data, err := b.LoadStateFn(spanCtx, sessionKey)
+	b.metrics.PersistenceObserved(LoadStateOperation, time.Since(start))
+	endSpan(span, err)
 	if err != nil {
-		return "", emptyData, &LoadStateError{err}
+		return "", emptyData, Meta[T]{}, &LoadStateError{err}
 	}
 	if state == "" {
 		state = UndefinedState
 	}
 
-	return state, data, nil
+	meta, err := b.loadMeta(ctx, sessionKey)
+	if err != nil {
+		return "", emptyData, Meta[T]{}, &LoadStateError{err}
+	}
+
+	state, meta = b.applyMissingStateFallback(state, meta)
+	return state, data, meta, nil
 }
 
-func getChatId(update *tgbotapi.Update) int64 {
-	var chatId int64
-	if update.Message != nil && update.Message.Chat != nil {
-		chatId = update.Message.Chat.ID
+func (b *BotFsm[T]) saveState(ctx context.Context, sessionKey SessionKey, state State, data T, meta Meta[T]) error {
+	ctx, span := b.tracer.Start(ctx, "SaveStateFn")
+	span.SetAttribute("state", state)
+	start := time.Now()
+	err := b.metaHandler.SaveStateWithMetaFn(ctx, sessionKey, state, data, meta)
+	b.metrics.PersistenceObserved(SaveStateOperation, time.Since(start))
+	endSpan(span, err)
+	if err != nil {
+		return &SaveStateError{err}
 	}
+	return nil
+}
 
-	if chatId == 0 && update.CallbackQuery != nil && update.CallbackQuery.Message != nil &&
-		update.CallbackQuery.Message.Chat != nil {
-		chatId = update.CallbackQuery.Message.Chat.ID
-	}
-	return chatId
+// metaPersisted reports whether Meta is stored by PersistenceHandler rather than kept in memory.
+func (b *BotFsm[T]) metaPersisted() bool {
+	_, ok := b.PersistenceHandler.(MetaPersistenceHandler[T])
+	return ok
 }
 
-func (b *BotFsm[T]) removeKeyboard(chatId int64) error {
+func (b *BotFsm[T]) loadMeta(ctx context.Context, sessionKey SessionKey) (Meta[T], error) {
+	ctx, span := b.tracer.Start(ctx, "LoadMetaFn")
+	start := time.Now()
+	meta, err := b.metaHandler.LoadMetaFn(ctx, sessionKey)
+	b.metrics.PersistenceObserved(LoadMetaOperation, time.Since(start))
+	endSpan(span, err)
+	return meta, err
+}
+
+// resolveTransition converts special (sub-flow, back) transitions into regular ones and updates meta accordingly.
+func (b *BotFsm[T]) resolveTransition(
+	ctx context.Context,
+	state State,
+	transition Transition,
+	data T,
+	meta Meta[T],
+) (Transition, T, Meta[T], error) {
+	if (transition.kind == backTransitionKind || transition.kind == callTransitionKind) && !b.metaPersisted() {
+		return transition, data, meta, &MetaPersistenceRequiredError{state}
+	}
+	if transition.kind == backTransitionKind {
+		return b.goBack(state, transition, data, meta)
+	}
+	var err error
+	transition, data, meta.Stack, err = b.resolveSubFlow(ctx, state, transition, data, meta.Stack)
+	return transition, data, meta, err
+}
+
+// getChatId returns id of the chat update belongs to. It's 0 for updates without chat, e.g. inline queries.
+func getChatId(update *tgbotapi.Update) int64 {
+	var chat *tgbotapi.Chat
+	switch {
+	case update.Message != nil:
+		chat = update.Message.Chat
+	case update.EditedMessage != nil:
+		chat = update.EditedMessage.Chat
+	case update.ChannelPost != nil:
+		chat = update.ChannelPost.Chat
+	case update.EditedChannelPost != nil:
+		chat = update.EditedChannelPost.Chat
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		chat = update.CallbackQuery.Message.Chat
+	case update.MyChatMember != nil:
+		chat = &update.MyChatMember.Chat
+	case update.ChatMember != nil:
+		chat = &update.ChatMember.Chat
+	case update.ChatJoinRequest != nil:
+		chat = &update.ChatJoinRequest.Chat
+	}
+	if chat == nil {
+		return 0
+	}
+	return chat.ID
+}
+
+func (b *BotFsm[T]) removeKeyboard(ctx context.Context, chatId int64, threadId int) (err error) {
+	ctx, span := b.tracer.Start(ctx, "removeKeyboard")
+	defer func() {
+		endSpan(span, err)
+	}()
 	msg := tgbotapi.NewMessage(chatId, b.removeKeyboardTempText)
 	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(false)
-	msgSent, err := b.bot.Send(msg)
+	msgSent, err := b.send(ctx, msg, threadId)
 	if err != nil {
 		return &DeleteKeyboardError{err}
 	}
 	deleteMsg := tgbotapi.NewDeleteMessage(msgSent.Chat.ID, msgSent.MessageID)
-	// This method always returns an error because of invalid bool to Message conversion.
-	b.bot.Send(deleteMsg) //nolint:errcheck // see comment above
+	// Deletion is best effort: the keyboard is already removed, only the temporary message is left on failure.
+	b.request(ctx, deleteMsg) //nolint:errcheck // see comment above
 	return nil
 }
 
+// send sends chattable. Messages and invoices are sent to the given forum topic, if threadId is not 0.
+func (b *BotFsm[T]) send(ctx context.Context, chattable tgbotapi.Chattable, threadId int) (tgbotapi.Message, error) {
+	_, span := b.tracer.Start(ctx, "Send")
+	start := time.Now()
+	var msg tgbotapi.Message
+	var err error
+	if threadId != 0 {
+		msg, err = b.sendToThread(chattable, threadId)
+	} else {
+		msg, err = b.bot.Send(chattable)
+	}
+	b.metrics.SendObserved(time.Since(start))
+	endSpan(span, err)
+	return msg, err
+}
+
+// request sends chattable whose result is not a message, e.g. answerInlineQuery.
+func (b *BotFsm[T]) request(ctx context.Context, chattable tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	_, span := b.tracer.Start(ctx, "Send")
+	start := time.Now()
+	resp, err := b.bot.Request(chattable)
+	b.metrics.SendObserved(time.Since(start))
+	endSpan(span, err)
+	return resp, err
+}
+
+// getStateMessageConfigs returns messages to send. Nothing is sent for empty text (e.g. for invoices or states without
+// message), since Telegram rejects such messages.
 func (b *BotFsm[T]) getStateMessageConfigs(chatId int64, messageConfig MessageConfig) []tgbotapi.MessageConfig {
+	if messageConfig.Text == "" {
+		return nil
+	}
 	msg := messageConfig.MessageConfig
 	msg.ChatID = chatId
 	msg.ParseMode = messageConfig.ParseMode
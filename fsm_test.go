@@ -0,0 +1,153 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeTelegram is Bot API server recording requests.
+type fakeTelegram struct {
+	mx       sync.Mutex
+	requests []fakeRequest
+	// Error code returned for every request, if it's not 0.
+	errorCode int
+}
+
+type fakeRequest struct {
+	Method string
+	Params url.Values
+}
+
+func newFakeTelegram(t *testing.T) (*fakeTelegram, *tgbotapi.BotAPI) {
+	t.Helper()
+	fake := &fakeTelegram{}
+	server := httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	t.Cleanup(server.Close)
+	bot := &tgbotapi.BotAPI{Token: "token", Client: server.Client()}
+	bot.SetAPIEndpoint(server.URL + "/bot%s/%s")
+	return fake, bot
+}
+
+func (f *fakeTelegram) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	f.mx.Lock()
+	f.requests = append(f.requests, fakeRequest{Method: method, Params: r.Form})
+	errorCode := f.errorCode
+	f.mx.Unlock()
+	switch {
+	case errorCode != 0:
+		fmt.Fprintf(w, `{"ok":false,"error_code":%d,"description":"error %d"}`, errorCode, errorCode)
+	case strings.HasPrefix(method, "send"):
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":%s}}}`, r.Form.Get("chat_id"))
+	default:
+		fmt.Fprint(w, `{"ok":true,"result":true}`)
+	}
+}
+
+func (f *fakeTelegram) setErrorCode(code int) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.errorCode = code
+}
+
+// sent returns requests of the given method.
+func (f *fakeTelegram) sent(method string) []fakeRequest {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	var requests []fakeRequest
+	for _, request := range f.requests {
+		if request.Method == method {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// sentTexts returns texts of sent messages.
+func (f *fakeTelegram) sentTexts() []string {
+	var texts []string
+	for _, request := range f.sent("sendMessage") {
+		texts = append(texts, request.Params.Get("text"))
+	}
+	return texts
+}
+
+func textUpdate(chatId int64, text string) *tgbotapi.Update {
+	return &tgbotapi.Update{Message: &tgbotapi.Message{
+		Text: text,
+		From: &tgbotapi.User{ID: chatId},
+		Chat: &tgbotapi.Chat{ID: chatId, Type: "private"},
+	}}
+}
+
+func TestGoToFromHandlerWithPendingReload(t *testing.T) {
+	_, bot := newFakeTelegram(t)
+	builder := New[int]()
+	builder.State(UndefinedState).Text("start")
+	builder.State("next").Text("next")
+	var botFsm *BotFsm[int]
+	builder.State(UndefinedState).OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+		configs, commands := builder.Configs()
+		reloaded := make(chan error)
+		go func() {
+			reloaded <- botFsm.Reload(configs, commands)
+		}()
+		if err := <-reloaded; err != nil {
+			t.Errorf("reload error: %s", err)
+		}
+		if err := botFsm.GoTo(ctx, 2, StateTransition("next"), data); err != nil {
+			t.Errorf("goto error: %s", err)
+		}
+		return Transition{}, data
+	})
+	botFsm = builder.Build(bot)
+
+	done := make(chan error)
+	go func() {
+		done <- botFsm.HandleUpdate(context.Background(), textUpdate(1, "hi"))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("HandleUpdate is blocked")
+	}
+}
+
+func TestConcurrentReload(t *testing.T) {
+	_, bot := newFakeTelegram(t)
+	builder := New[int]()
+	builder.State(UndefinedState).Text("start")
+	botFsm := builder.Build(bot)
+	configs, commands := builder.Configs()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := botFsm.Reload(configs, commands); err != nil {
+				t.Errorf("reload error: %s", err)
+			}
+		}()
+		go func(chatId int64) {
+			defer wg.Done()
+			if err := botFsm.HandleUpdate(context.Background(), textUpdate(chatId, "hi")); err != nil {
+				t.Errorf("update error: %s", err)
+			}
+		}(int64(i + 1))
+	}
+	wg.Wait()
+}
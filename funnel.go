@@ -0,0 +1,205 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// StateStats contains analytics of a single state.
+type StateStats struct {
+	State
+	// Number of transitions into the state from another state.
+	Entries int64
+	// Number of transitions from the state to another state.
+	Exits int64
+	// Number of stays whose both entry and exit were observed.
+	CompletedStays int64
+	// Total time of completed stays.
+	TotalTime time.Duration
+}
+
+// AverageTime returns average time of completed stays.
+func (s StateStats) AverageTime() time.Duration {
+	if s.CompletedStays == 0 {
+		return 0
+	}
+	return s.TotalTime / time.Duration(s.CompletedStays)
+}
+
+// FunnelStep contains conversion information of a single checkpoint.
+type FunnelStep struct {
+	State
+	// Number of sessions which reached the checkpoint after all previous ones.
+	Reached int64
+	// Number of sessions which reached the previous checkpoint, but not this one. It's 0 for the first checkpoint.
+	DroppedOut int64
+	// Reached share of sessions reached the previous checkpoint.
+	StepConversion float64
+	// Reached share of sessions reached the first checkpoint.
+	TotalConversion float64
+}
+
+// defaultFunnelIdleTimeout is the default time after which idle sessions are forgotten by FunnelAnalytics.
+const defaultFunnelIdleTimeout = 24 * time.Hour
+
+// FunnelAnalytics is a TransitionObserver which records entries, exits and time in state per state and computes
+// funnel conversion between checkpoints. Use NewFunnelAnalytics to create it.
+type FunnelAnalytics struct {
+	mx          sync.Mutex
+	checkpoints []State
+	states      map[State]*StateStats
+	chats       map[SessionKey]*funnelChat
+	reached     []int64
+	idleTimeout time.Duration
+	evictedAt   time.Time
+}
+
+type funnelChat struct {
+	state     State
+	enteredAt time.Time
+	// Number of checkpoints reached in order.
+	progress int
+}
+
+// NewFunnelAnalytics creates FunnelAnalytics. Checkpoints are the states of the funnel in the expected order. A session
+// reaches a checkpoint when it enters the checkpoint state after reaching all previous checkpoints. Every session is
+// counted once per checkpoint, unless it's forgotten after being idle, see SetIdleTimeout.
+func NewFunnelAnalytics(checkpoints ...State) *FunnelAnalytics {
+	return &FunnelAnalytics{
+		checkpoints: checkpoints,
+		states:      make(map[State]*StateStats),
+		chats:       make(map[SessionKey]*funnelChat),
+		reached:     make([]int64, len(checkpoints)),
+		idleTimeout: defaultFunnelIdleTimeout,
+	}
+}
+
+// SetIdleTimeout sets the time after which sessions without transitions are forgotten, so memory isn't held by
+// abandoned sessions. The current stay of a forgotten session isn't counted as completed, and the session starts the
+// funnel over on its next transition. It's 24 hours by default, 0 disables forgetting.
+func (f *FunnelAnalytics) SetIdleTimeout(timeout time.Duration) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.idleTimeout = timeout
+}
+
+func (f *FunnelAnalytics) ObserveTransition(ctx context.Context, event TransitionEvent) {
+	if event.From == event.To {
+		return
+	}
+	now := time.Now()
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.evictIdle(now)
+
+	chat, ok := f.chats[event.SessionKey]
+	if !ok {
+		chat = &funnelChat{}
+		f.chats[event.SessionKey] = chat
+	}
+	from := f.getStats(event.From)
+	from.Exits++
+	if chat.state == event.From && !chat.enteredAt.IsZero() {
+		from.CompletedStays++
+		from.TotalTime += now.Sub(chat.enteredAt)
+	}
+	f.getStats(event.To).Entries++
+	chat.state, chat.enteredAt = event.To, now
+
+	if chat.progress < len(f.checkpoints) && f.checkpoints[chat.progress] == event.To {
+		f.reached[chat.progress]++
+		chat.progress++
+	}
+}
+
+// evictIdle forgets idle sessions. Sessions are checked at most once per idle timeout, so a session is kept for at most
+// twice the timeout.
+func (f *FunnelAnalytics) evictIdle(now time.Time) {
+	if f.idleTimeout <= 0 || now.Sub(f.evictedAt) < f.idleTimeout {
+		return
+	}
+	for sessionKey, chat := range f.chats {
+		if now.Sub(chat.enteredAt) >= f.idleTimeout {
+			delete(f.chats, sessionKey)
+		}
+	}
+	f.evictedAt = now
+}
+
+func (f *FunnelAnalytics) getStats(state State) *StateStats {
+	stats, ok := f.states[state]
+	if !ok {
+		stats = &StateStats{State: state}
+		f.states[state] = stats
+	}
+	return stats
+}
+
+// StateStats returns analytics of the given state.
+func (f *FunnelAnalytics) StateStats(state State) StateStats {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if stats, ok := f.states[state]; ok {
+		return *stats
+	}
+	return StateStats{State: state}
+}
+
+// States returns analytics of all observed states sorted by state name.
+func (f *FunnelAnalytics) States() []StateStats {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	states := make([]StateStats, 0, len(f.states))
+	for _, stats := range f.states {
+		states = append(states, *stats)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].State < states[j].State
+	})
+	return states
+}
+
+// Funnel returns conversion information for every checkpoint.
+func (f *FunnelAnalytics) Funnel() []FunnelStep {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	steps := make([]FunnelStep, len(f.checkpoints))
+	for i, checkpoint := range f.checkpoints {
+		steps[i] = FunnelStep{State: checkpoint, Reached: f.reached[i]}
+		if i == 0 {
+			if f.reached[i] > 0 {
+				steps[i].StepConversion, steps[i].TotalConversion = 1, 1
+			}
+			continue
+		}
+		steps[i].DroppedOut = f.reached[i-1] - f.reached[i]
+		if f.reached[i-1] > 0 {
+			steps[i].StepConversion = float64(f.reached[i]) / float64(f.reached[i-1])
+		}
+		if f.reached[0] > 0 {
+			steps[i].TotalConversion = float64(f.reached[i]) / float64(f.reached[0])
+		}
+	}
+	return steps
+}
+
+// Report writes funnel and states analytics to w as plain text tables.
+func (f *FunnelAnalytics) Report(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECKPOINT\tREACHED\tDROPPED OUT\tSTEP CONVERSION\tTOTAL CONVERSION")
+	for _, step := range f.Funnel() {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\t%.1f%%\n", step.State, step.Reached, step.DroppedOut,
+			step.StepConversion*100, step.TotalConversion*100)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "STATE\tENTRIES\tEXITS\tAVERAGE TIME")
+	for _, stats := range f.States() {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", stats.State, stats.Entries, stats.Exits, stats.AverageTime())
+	}
+	return tw.Flush()
+}
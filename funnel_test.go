@@ -0,0 +1,31 @@
+package fsm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFunnelAnalyticsIdleSessions(t *testing.T) {
+	analytics := NewFunnelAnalytics("name", "done")
+	analytics.SetIdleTimeout(10 * time.Millisecond)
+	ctx := context.Background()
+	transition := func(chatId int64, from, to State) {
+		analytics.ObserveTransition(ctx, TransitionEvent{SessionKey: SessionKey{ChatId: chatId}, From: from, To: to})
+	}
+
+	transition(1, UndefinedState, "name")
+	transition(2, UndefinedState, "name")
+	time.Sleep(20 * time.Millisecond)
+	transition(2, "name", "done")
+	if len(analytics.chats) != 1 {
+		t.Fatalf("expected idle session forgotten, got %d sessions", len(analytics.chats))
+	}
+	if stats := analytics.StateStats("name"); stats.Exits != 1 || stats.CompletedStays != 0 {
+		t.Fatalf("expected stay of forgotten session not completed, got %+v", stats)
+	}
+	steps := analytics.Funnel()
+	if steps[0].Reached != 2 || steps[1].Reached != 0 {
+		t.Fatalf("expected forgotten session to start over, got %+v", steps)
+	}
+}
@@ -1,5 +1,8 @@
 module github.com/Feolius/telegram-bot-fsm
 
-go 1.18.0
+go 1.21.0
 
-require github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+require (
+	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,172 @@
+package fsm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TargetStatesProvider may be implemented by StateHandler or command TransitionProvider to declare states it may
+// switch to. Declared states are used for the graph export only, they don't restrict transitions.
+type TargetStatesProvider interface {
+	TargetStates() []State
+}
+
+// GraphEdge describes a connection between 2 states.
+type GraphEdge struct {
+	// Source state. AnyState means that the edge is global (e.g. a command).
+	From State
+	To   State
+	// Optional edge description, e.g. a command name.
+	Label string
+}
+
+// Graph describes FSM states and connections between them.
+type Graph struct {
+	States []State
+	Edges  []GraphEdge
+}
+
+// Graph builds the static FSM graph. Edges are taken from transition rules, state handlers and commands
+// implementing TargetStatesProvider. Commands are represented as global edges labeled with the command name. Commands
+// without declared target states aren't represented, Validate warns about them.
+func (b *BotFsm[T]) Graph() *Graph {
+	return b.snapshot().graph()
+}
+
+func (b *BotFsm[T]) graph() *Graph {
+	edges := make([]GraphEdge, 0, len(b.transitionRules))
+	for _, rule := range b.transitionRules {
+		edges = append(edges, GraphEdge{From: rule.From, To: rule.To})
+	}
+	for state, stateHandler := range b.configs {
+		if isNil(stateHandler) {
+			continue
+		}
+		if targetStatesProvider, ok := handlerAs[TargetStatesProvider](stateHandler); ok {
+			for _, targetState := range targetStatesProvider.TargetStates() {
+				edges = append(edges, GraphEdge{From: state, To: targetState})
+			}
+		}
+	}
+	for command, commandHandler := range b.commands {
+		if isNil(commandHandler) {
+			continue
+		}
+		if targetStatesProvider, ok := handlerAs[TargetStatesProvider](commandHandler); ok {
+			for _, targetState := range targetStatesProvider.TargetStates() {
+				edges = append(edges, GraphEdge{From: AnyState, To: targetState, Label: "/" + command})
+			}
+		}
+	}
+
+	states := make([]State, 0, len(b.configs))
+	for state := range b.configs {
+		states = append(states, state)
+	}
+	return newGraph(states, edges)
+}
+
+// newGraph creates a graph with sorted states and edges. States mentioned in edges only are added as well.
+func newGraph(states []State, edges []GraphEdge) *Graph {
+	statesSet := make(map[State]struct{}, len(states))
+	for _, state := range states {
+		statesSet[state] = struct{}{}
+	}
+	edgesSet := make(map[GraphEdge]struct{}, len(edges))
+	graph := &Graph{}
+	for _, edge := range edges {
+		if _, ok := edgesSet[edge]; ok {
+			continue
+		}
+		edgesSet[edge] = struct{}{}
+		graph.Edges = append(graph.Edges, edge)
+		for _, state := range []State{edge.From, edge.To} {
+			if _, ok := statesSet[state]; !ok && state != AnyState {
+				statesSet[state] = struct{}{}
+				states = append(states, state)
+			}
+		}
+	}
+	graph.States = states
+	sort.Strings(graph.States)
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		a, b := graph.Edges[i], graph.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Label < b.Label
+	})
+	return graph
+}
+
+// DOT renders the graph in Graphviz DOT format. Global edges start from a separate "any state" node.
+func (g *Graph) DOT() string {
+	sb := strings.Builder{}
+	sb.WriteString("digraph fsm {\n")
+	if g.hasGlobalEdges() {
+		sb.WriteString("\t\"*\" [label=\"any state\", shape=plaintext];\n")
+	}
+	for _, state := range g.States {
+		fmt.Fprintf(&sb, "\t%q;\n", state)
+	}
+	for _, edge := range g.Edges {
+		attrs := make([]string, 0, 2)
+		if edge.Label != "" {
+			attrs = append(attrs, fmt.Sprintf("label=%q", edge.Label))
+		}
+		if edge.From == AnyState {
+			attrs = append(attrs, "style=dashed")
+		}
+		fmt.Fprintf(&sb, "\t%q -> %q", edge.From, edge.To)
+		if len(attrs) > 0 {
+			fmt.Fprintf(&sb, " [%s]", strings.Join(attrs, ", "))
+		}
+		sb.WriteString(";\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// Mermaid renders the graph as Mermaid flowchart. Global edges start from a separate "any state" node.
+func (g *Graph) Mermaid() string {
+	ids := make(map[State]string, len(g.States)+1)
+	ids[AnyState] = "any"
+	sb := strings.Builder{}
+	sb.WriteString("flowchart TD\n")
+	if g.hasGlobalEdges() {
+		sb.WriteString("\tany((\"any state\"))\n")
+	}
+	for i, state := range g.States {
+		ids[state] = fmt.Sprintf("s%d", i)
+		fmt.Fprintf(&sb, "\t%s[\"%s\"]\n", ids[state], mermaidEscape(state))
+	}
+	for _, edge := range g.Edges {
+		arrow := "-->"
+		if edge.From == AnyState {
+			arrow = "-.->"
+		}
+		if edge.Label != "" {
+			fmt.Fprintf(&sb, "\t%s %s|\"%s\"| %s\n", ids[edge.From], arrow, mermaidEscape(edge.Label), ids[edge.To])
+		} else {
+			fmt.Fprintf(&sb, "\t%s %s %s\n", ids[edge.From], arrow, ids[edge.To])
+		}
+	}
+	return sb.String()
+}
+
+func (g *Graph) hasGlobalEdges() bool {
+	for _, edge := range g.Edges {
+		if edge.From == AnyState {
+			return true
+		}
+	}
+	return false
+}
+
+func mermaidEscape(s string) string {
+	return strings.ReplaceAll(s, "\"", "#quot;")
+}
@@ -0,0 +1,72 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+)
+
+// AnyState matches any state in TransitionRule From field.
+const AnyState = "*"
+
+// IllegalTransitionError Returned on attempt to perform transition which is not allowed by transition rules.
+type IllegalTransitionError struct {
+	From State
+	To   State
+}
+
+func (e *IllegalTransitionError) Error() string {
+	return fmt.Sprintf("transition from %s to %s is not allowed", e.From, e.To)
+}
+
+// TransitionGuardError Returned when transition is declared in transition rules, but rejected by the guard.
+type TransitionGuardError struct {
+	From State
+	To   State
+}
+
+func (e *TransitionGuardError) Error() string {
+	return fmt.Sprintf("transition from %s to %s is rejected by guard", e.From, e.To)
+}
+
+// GuardFn decides whether the transition is allowed. It receives data returned by TransitionFn.
+type GuardFn[T any] func(ctx context.Context, data T) bool
+
+// TransitionRule declares allowed transition between 2 states.
+type TransitionRule[T any] struct {
+	// Source state. AnyState can be used to allow transition from any state.
+	From State
+	// Target state.
+	To State
+	// Optional guard. If it's provided, transition is allowed only when guard returns true.
+	Guard GuardFn[T]
+}
+
+// WithTransitionRules restricts transitions to the declared ones. Transitions to the same state are always allowed.
+// Note: commands are treated as transitions from UndefinedState, since the state is reset before command handler call.
+func WithTransitionRules[T any](rules ...TransitionRule[T]) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.transitionRules = append(opts.transitionRules, rules...)
+	}
+}
+
+// checkTransition verifies that transition is allowed by the rules. If no rules are defined, any transition is
+// allowed.
+func (b *BotFsm[T]) checkTransition(ctx context.Context, from State, to State, data T) error {
+	if len(b.transitionRules) == 0 || from == to {
+		return nil
+	}
+	declared := false
+	for _, rule := range b.transitionRules {
+		if (rule.From != from && rule.From != AnyState) || rule.To != to {
+			continue
+		}
+		if rule.Guard == nil || rule.Guard(ctx, data) {
+			return nil
+		}
+		declared = true
+	}
+	if declared {
+		return &TransitionGuardError{From: from, To: to}
+	}
+	return &IllegalTransitionError{From: from, To: to}
+}
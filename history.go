@@ -0,0 +1,78 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// EmptyHistoryError Returned on attempt to go back when there is no previous state in the history.
+type EmptyHistoryError struct {
+	State
+}
+
+func (e *EmptyHistoryError) Error() string {
+	return fmt.Sprintf("cannot go back from state %s: history is empty", e.State)
+}
+
+// HistoryEntry describes a previously visited state.
+type HistoryEntry[T any] struct {
+	State
+	// Data snapshot taken when the bot left the state. Note: data is copied by value, so reference types (slices,
+	// maps, pointers) inside it are shared with the current data.
+	Data T
+	// Sub-flow call stack snapshot.
+	Stack []StackFrame
+}
+
+// BackTransition switches bot to the previous state from the history and restores its data. Previous state
+// MessageFn is called, unless MessageConfig is set for the transition. History must be enabled with WithHistory
+// option.
+func BackTransition() Transition {
+	return Transition{kind: backTransitionKind}
+}
+
+// BackCommandHandler is a built-in command handler performing BackTransition. Usually, it's registered as "back"
+// command.
+type BackCommandHandler[T any] struct{}
+
+func (h BackCommandHandler[T]) TransitionFn(ctx context.Context, update *tgbotapi.Update, data T) (Transition, T) {
+	return BackTransition(), data
+}
+
+// WithHistory enables state history. size is the maximum number of kept history entries per chat.
+func WithHistory[T any](size int) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.historySize = size
+	}
+}
+
+// goBack converts back transition into a regular one, restoring the previous state data and call stack.
+func (b *BotFsm[T]) goBack(state State, transition Transition, data T, meta Meta[T]) (Transition, T, Meta[T], error) {
+	if len(meta.History) == 0 {
+		return transition, data, meta, &EmptyHistoryError{state}
+	}
+	entry := meta.History[len(meta.History)-1]
+	meta.History = meta.History[:len(meta.History)-1]
+	meta.Stack = entry.Stack
+	transition.State = entry.State
+	transition.kind = regularTransitionKind
+	return transition, entry.Data, meta, nil
+}
+
+// recordHistory adds the state bot is leaving to the history.
+func (b *BotFsm[T]) recordHistory(meta Meta[T], state State, data T, stack []StackFrame) Meta[T] {
+	if b.historySize <= 0 {
+		return meta
+	}
+	meta.History = append(meta.History, HistoryEntry[T]{
+		State: state,
+		Data:  data,
+		Stack: append([]StackFrame(nil), stack...),
+	})
+	if len(meta.History) > b.historySize {
+		meta.History = meta.History[len(meta.History)-b.historySize:]
+	}
+	return meta
+}
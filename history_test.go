@@ -0,0 +1,77 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestHistory(t *testing.T) {
+	fake, bot := newFakeTelegram(t)
+	builder := New[int]()
+	states := []State{UndefinedState, "first", "second", "third"}
+	for i, state := range states {
+		builder.State(state).Text(state)
+		if i < len(states)-1 {
+			next := states[i+1]
+			builder.State(state).OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+				return StateTransition(next), data + 1
+			})
+		}
+	}
+	configs, commands := builder.Configs()
+	commands["back"] = BackCommandHandler[int]{}
+	botFsm := NewBotFsm(bot, configs, WithCommands[int](commands), WithHistory[int](2))
+	ctx := context.Background()
+	sessionKey := SessionKey{ChatId: 1}
+
+	for i := 0; i < 3; i++ {
+		if err := botFsm.HandleUpdate(ctx, textUpdate(1, "next")); err != nil {
+			t.Fatalf("update error: %s", err)
+		}
+	}
+	_, _, meta, err := botFsm.loadState(ctx, sessionKey)
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	if len(meta.History) != 2 || meta.History[0].State != "first" || meta.History[1].State != "second" {
+		t.Fatalf("expected history trimmed to 2 last states, got %v", meta.History)
+	}
+
+	tests := []struct {
+		state   State
+		data    int
+		history int
+	}{
+		{"second", 2, 1},
+		{"first", 1, 0},
+	}
+	for _, test := range tests {
+		if err = botFsm.HandleUpdate(ctx, commandUpdate(1, "back")); err != nil {
+			t.Fatalf("back error: %s", err)
+		}
+		state, data, meta, err := botFsm.loadState(ctx, sessionKey)
+		if err != nil {
+			t.Fatalf("load error: %s", err)
+		}
+		if state != test.state || data != test.data || len(meta.History) != test.history {
+			t.Fatalf("expected state %s, data %d and %d history entries, got %s, %d and %v",
+				test.state, test.data, test.history, state, data, meta.History)
+		}
+	}
+	texts := fake.sentTexts()
+	if len(texts) != 5 || texts[3] != "second" || texts[4] != "first" {
+		t.Fatalf("unexpected messages: %v", texts)
+	}
+
+	err = botFsm.HandleUpdate(ctx, commandUpdate(1, "back"))
+	var emptyHistoryErr *EmptyHistoryError
+	if !errors.As(err, &emptyHistoryErr) {
+		t.Fatalf("expected EmptyHistoryError, got %v", err)
+	}
+	if state, _, _, _ := botFsm.loadState(ctx, sessionKey); state != "first" {
+		t.Fatalf("expected state not changed, got %s", state)
+	}
+}
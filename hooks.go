@@ -0,0 +1,70 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+)
+
+// EnterStateError Error wrapper for OnEnter hook error.
+type EnterStateError struct {
+	State
+	Err error
+}
+
+func (e *EnterStateError) Error() string {
+	return fmt.Sprintf("entering state %s error: %s", e.State, e.Err)
+}
+
+func (e *EnterStateError) Unwrap() error {
+	return e.Err
+}
+
+// ExitStateError Error wrapper for OnExit hook error.
+type ExitStateError struct {
+	State
+	Err error
+}
+
+func (e *ExitStateError) Error() string {
+	return fmt.Sprintf("exiting state %s error: %s", e.State, e.Err)
+}
+
+func (e *ExitStateError) Unwrap() error {
+	return e.Err
+}
+
+// EnterHandler may be implemented by StateHandler to perform some actions when bot enters the state.
+type EnterHandler[T any] interface {
+	// OnEnter is called right before the state MessageFn. Returned data is passed to MessageFn and saved. If error is
+	// returned, the transition is canceled.
+	OnEnter(ctx context.Context, data T) (T, error)
+}
+
+// ExitHandler may be implemented by StateHandler to perform some actions when bot leaves the state.
+type ExitHandler[T any] interface {
+	// OnExit is called right after the transition to another state is chosen. Returned data is passed to the next
+	// state. If error is returned, the transition is canceled.
+	OnExit(ctx context.Context, data T) (T, error)
+}
+
+// runHooks calls OnExit hook of the state bot leaves and OnEnter hook of the state bot enters. Hooks are called only
+// when the state is actually changed.
+func (b *BotFsm[T]) runHooks(ctx context.Context, from State, to State, data T) (T, error) {
+	if from == to {
+		return data, nil
+	}
+	var err error
+	if exitHandler, ok := handlerAs[ExitHandler[T]](b.configs[from]); ok {
+		data, err = exitHandler.OnExit(ctx, data)
+		if err != nil {
+			return data, &ExitStateError{from, err}
+		}
+	}
+	if enterHandler, ok := handlerAs[EnterHandler[T]](b.configs[to]); ok {
+		data, err = enterHandler.OnEnter(ctx, data)
+		if err != nil {
+			return data, &EnterStateError{to, err}
+		}
+	}
+	return data, nil
+}
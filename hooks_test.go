@@ -0,0 +1,44 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestHooksNotRunForMissingState(t *testing.T) {
+	_, bot := newFakeTelegram(t)
+	builder := New[int]()
+	var exits int
+	builder.State(UndefinedState).Text("start").
+		OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+			return StateTransition("missing"), data
+		}).
+		OnExit(func(ctx context.Context, data int) (int, error) {
+			exits++
+			return data, nil
+		})
+	botFsm := builder.Build(bot)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		run  func() error
+	}{
+		{"update", func() error { return botFsm.HandleUpdate(ctx, textUpdate(1, "hi")) }},
+		{"goto", func() error { return botFsm.GoTo(ctx, 1, StateTransition("missing"), 0) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var notFoundErr *NextStateConfigNotFoundError
+			if err := test.run(); !errors.As(err, &notFoundErr) {
+				t.Fatalf("expected NextStateConfigNotFoundError, got %v", err)
+			}
+			if exits != 0 {
+				t.Fatalf("expected no OnExit calls, got %d", exits)
+			}
+		})
+	}
+}
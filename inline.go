@@ -0,0 +1,131 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// InlineQueryError Error wrapper for InlineQueryFn error.
+type InlineQueryError struct {
+	Query string
+	Err   error
+}
+
+func (e *InlineQueryError) Error() string {
+	return fmt.Sprintf("inline query %q error: %s", e.Query, e.Err)
+}
+
+func (e *InlineQueryError) Unwrap() error {
+	return e.Err
+}
+
+// InlineQueryHandler answers inline queries (@bot query in any chat).
+type InlineQueryHandler[T any] interface {
+	// InlineQueryFn returns the answer to the inline query. State and data belong to the session of the user private
+	// chat with the bot. Answer InlineQueryID is filled automatically.
+	InlineQueryFn(ctx context.Context, query *tgbotapi.InlineQuery, state State, data T) (tgbotapi.InlineConfig, error)
+}
+
+// ChosenInlineResultHandler handles inline results chosen by users. Bot receives them only if inline feedback is
+// enabled via @BotFather.
+type ChosenInlineResultHandler[T any] interface {
+	// ChosenInlineResultFn returns the transition which is performed in the user private chat with the bot, as GoTo
+	// does. State and data belong to the session of this chat. If Transition State is empty, nothing is performed.
+	ChosenInlineResultFn(
+		ctx context.Context,
+		result *tgbotapi.ChosenInlineResult,
+		state State,
+		data T,
+	) (Transition, T, error)
+}
+
+func WithInlineQueryHandler[T any](handler InlineQueryHandler[T]) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.inlineQueryHandler = handler
+	}
+}
+
+func WithChosenInlineResultHandler[T any](handler ChosenInlineResultHandler[T]) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.chosenInlineResultHandler = handler
+	}
+}
+
+// InlineQueryOffset returns the offset of the requested results page. It's 0 for the first page. Use it when results
+// are fetched from storage page by page, and set answer NextOffset to the offset of the next page (or leave it empty
+// if there are no more results).
+func InlineQueryOffset(query *tgbotapi.InlineQuery) int {
+	offset, err := strconv.Atoi(query.Offset)
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// maxInlineResults is the maximum number of results per inline query answer allowed by Telegram.
+const maxInlineResults = 50
+
+// InlineResultsPage builds the answer containing the requested page of results. NextOffset is set if there are more
+// results. pageSize is clamped to 1..50, since Telegram allows at most 50 results per answer.
+func InlineResultsPage(query *tgbotapi.InlineQuery, results []any, pageSize int) tgbotapi.InlineConfig {
+	pageSize = min(max(pageSize, 1), maxInlineResults)
+	answer := tgbotapi.InlineConfig{InlineQueryID: query.ID, Results: []any{}}
+	offset := InlineQueryOffset(query)
+	if offset >= len(results) {
+		return answer
+	}
+	end := offset + pageSize
+	if end < len(results) {
+		answer.NextOffset = strconv.Itoa(end)
+	} else {
+		end = len(results)
+	}
+	answer.Results = results[offset:end]
+	return answer
+}
+
+func (b *BotFsm[T]) answerInlineQuery(ctx context.Context, update *tgbotapi.Update) error {
+	query := update.InlineQuery
+	if query.From == nil {
+		return &NoUserIdError{update}
+	}
+	state, data, _, err := b.loadState(ctx, b.getPrivateSessionKey(query.From.ID))
+	if err != nil {
+		return err
+	}
+	answer, err := b.inlineQueryHandler.InlineQueryFn(ctx, query, state, data)
+	if err != nil {
+		return &InlineQueryError{Query: query.Query, Err: err}
+	}
+	if answer.InlineQueryID == "" {
+		answer.InlineQueryID = query.ID
+	}
+	if answer.Results == nil {
+		answer.Results = []any{}
+	}
+	_, err = b.request(ctx, answer)
+	return err
+}
+
+func (b *BotFsm[T]) handleChosenInlineResult(ctx context.Context, logger fsmLogger, update *tgbotapi.Update) error {
+	result := update.ChosenInlineResult
+	if result.From == nil {
+		return &NoUserIdError{update}
+	}
+	sessionKey := b.getPrivateSessionKey(result.From.ID)
+	state, data, _, err := b.loadState(ctx, sessionKey)
+	if err != nil {
+		return err
+	}
+	transition, newData, err := b.chosenInlineResultHandler.ChosenInlineResultFn(ctx, result, state, data)
+	if err != nil {
+		return &TransitionError{State: state, Err: err}
+	}
+	if transition.State == "" {
+		return nil
+	}
+	return b.switchState(ctx, logger, sessionKey, result.From.ID, 0, transition, newData)
+}
@@ -0,0 +1,36 @@
+package fsm
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestInlineResultsPage(t *testing.T) {
+	results := make([]any, 120)
+	tests := []struct {
+		name       string
+		offset     string
+		pageSize   int
+		size       int
+		nextOffset string
+	}{
+		{"first page", "", 10, 10, "10"},
+		{"last page", "110", 20, 10, ""},
+		{"offset out of range", "200", 10, 0, ""},
+		{"invalid offset", "abc", 10, 10, "10"},
+		{"zero page size", "", 0, 1, "1"},
+		{"negative page size", "5", -3, 1, "6"},
+		{"page size over limit", "", 100, 50, "50"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := &tgbotapi.InlineQuery{ID: "q", Offset: test.offset}
+			answer := InlineResultsPage(query, results, test.pageSize)
+			if len(answer.Results) != test.size || answer.NextOffset != test.nextOffset {
+				t.Fatalf("expected %d results and next offset %q, got %d and %q",
+					test.size, test.nextOffset, len(answer.Results), answer.NextOffset)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ForbiddenError Returned when Telegram forbids sending messages to the chat: the bot is blocked by the user or
+// removed from the group. The session is marked inactive.
+type ForbiddenError struct {
+	ChatId int64
+	Err    error
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("sending to chat %d forbidden: %s", e.ChatId, e.Err)
+}
+
+func (e *ForbiddenError) Unwrap() error {
+	return e.Err
+}
+
+// LifecycleHookError Error wrapper for lifecycle hook error.
+type LifecycleHookError struct {
+	LifecycleEventType
+	Err error
+}
+
+func (e *LifecycleHookError) Error() string {
+	return fmt.Sprintf("%s hook error: %s", e.LifecycleEventType, e.Err)
+}
+
+func (e *LifecycleHookError) Unwrap() error {
+	return e.Err
+}
+
+// LifecycleEventType describes the change of the bot membership in the chat.
+type LifecycleEventType string
+
+const (
+	BlockedEvent          LifecycleEventType = "blocked"
+	UnblockedEvent        LifecycleEventType = "unblocked"
+	AddedToGroupEvent     LifecycleEventType = "added_to_group"
+	RemovedFromGroupEvent LifecycleEventType = "removed_from_group"
+)
+
+// LifecycleEvent is passed to lifecycle hooks.
+type LifecycleEvent struct {
+	Type   LifecycleEventType
+	ChatId int64
+	// Session marked active or inactive. It's empty, if the session isn't bound to the chat, e.g. for group events
+	// under PerUserSession strategy, so no session is marked.
+	SessionKey SessionKey
+	// The update which caused the event. It's nil if the event is caused by a send error.
+	Update *tgbotapi.Update
+	// The send error which caused the event. It's nil if the event is caused by an update.
+	Err error
+}
+
+// LifecycleHookFn is called when the bot membership in the chat changes. The session is already marked
+// active or inactive when the hook is called.
+type LifecycleHookFn func(ctx context.Context, event LifecycleEvent) error
+
+// LifecycleHooks are called on my_chat_member updates, on send errors caused by the bot being blocked or removed and on
+// updates reactivating inactive sessions. Channels are treated as groups. Hooks are called during update processing,
+// so they must not call HandleUpdate or GoTo. Nil hooks are skipped.
+type LifecycleHooks struct {
+	// The user blocked the bot in the private chat. The session is marked inactive.
+	OnBlocked LifecycleHookFn
+	// The user unblocked the bot in the private chat or the inactive session received an update from it. The session
+	// is marked active.
+	OnUnblocked LifecycleHookFn
+	// The bot was added to the group or the inactive session received an update from it. The session is marked active.
+	OnAddedToGroup LifecycleHookFn
+	// The bot was removed from the group. The session is marked inactive.
+	OnRemovedFromGroup LifecycleHookFn
+}
+
+// WithLifecycleHooks sets the hooks called when the bot membership in the chat changes. Sessions are marked inactive
+// regardless of the hooks.
+func WithLifecycleHooks[T any](hooks LifecycleHooks) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.lifecycleHooks = hooks
+	}
+}
+
+func (h LifecycleHooks) hook(eventType LifecycleEventType) LifecycleHookFn {
+	switch eventType {
+	case BlockedEvent:
+		return h.OnBlocked
+	case UnblockedEvent:
+		return h.OnUnblocked
+	case AddedToGroupEvent:
+		return h.OnAddedToGroup
+	default:
+		return h.OnRemovedFromGroup
+	}
+}
+
+// handleChatMemberUpdate marks the session active or inactive according to the my_chat_member update, saves it and
+// runs the lifecycle hook. Updates which don't change the bot membership (e.g. promotion to administrator) are
+// skipped.
+func (b *BotFsm[T]) handleChatMemberUpdate(
+	ctx context.Context,
+	logger fsmLogger,
+	sessionKey SessionKey,
+	update *tgbotapi.Update,
+	state State,
+	data T,
+	meta Meta[T],
+) (Meta[T], error) {
+	eventType, ok := getLifecycleEventType(update.MyChatMember)
+	if !ok {
+		return meta, nil
+	}
+	chatId := update.MyChatMember.Chat.ID
+	if !isSessionBoundToChat(sessionKey, chatId) {
+		sessionKey = SessionKey{}
+	} else {
+		meta.Inactive = eventType == BlockedEvent || eventType == RemovedFromGroupEvent
+		err := b.saveState(ctx, sessionKey, state, data, meta)
+		if err != nil {
+			return meta, err
+		}
+	}
+	return meta, b.runLifecycleHook(ctx, logger, LifecycleEvent{
+		Type:       eventType,
+		ChatId:     chatId,
+		SessionKey: sessionKey,
+		Update:     update,
+	})
+}
+
+// reactivateSession marks the inactive session active, saves it and runs the lifecycle hook, since the update from the
+// session chat means the bot is reachable there again.
+func (b *BotFsm[T]) reactivateSession(
+	ctx context.Context,
+	logger fsmLogger,
+	sessionKey SessionKey,
+	chatId int64,
+	update *tgbotapi.Update,
+	state State,
+	data T,
+	meta Meta[T],
+) (Meta[T], error) {
+	if !meta.Inactive || !isSessionBoundToChat(sessionKey, chatId) {
+		return meta, nil
+	}
+	meta.Inactive = false
+	err := b.saveState(ctx, sessionKey, state, data, meta)
+	if err != nil {
+		return meta, err
+	}
+	eventType := UnblockedEvent
+	if chatId < 0 {
+		eventType = AddedToGroupEvent
+	}
+	return meta, b.runLifecycleHook(ctx, logger, LifecycleEvent{
+		Type:       eventType,
+		ChatId:     chatId,
+		SessionKey: sessionKey,
+		Update:     update,
+	})
+}
+
+// handleSendError marks the session inactive and runs the lifecycle hook, if Telegram forbids sending messages to the
+// chat. Other errors are returned as is. Positive chat ids belong to private chats, negative ones to groups and
+// channels.
+func (b *BotFsm[T]) handleSendError(
+	ctx context.Context,
+	logger fsmLogger,
+	sessionKey SessionKey,
+	chatId int64,
+	err error,
+) error {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return err
+	}
+	forbiddenErr := &ForbiddenError{ChatId: chatId, Err: err}
+	if !isSessionBoundToChat(sessionKey, chatId) {
+		sessionKey = SessionKey{}
+	} else {
+		state, data, meta, err := b.loadState(ctx, sessionKey)
+		if err != nil {
+			return errors.Join(forbiddenErr, err)
+		}
+		meta.Inactive = true
+		err = b.saveState(ctx, sessionKey, state, data, meta)
+		if err != nil {
+			return errors.Join(forbiddenErr, err)
+		}
+	}
+	eventType := BlockedEvent
+	if chatId < 0 {
+		eventType = RemovedFromGroupEvent
+	}
+	err = b.runLifecycleHook(ctx, logger, LifecycleEvent{
+		Type:       eventType,
+		ChatId:     chatId,
+		SessionKey: sessionKey,
+		Err:        forbiddenErr.Err,
+	})
+	if err != nil {
+		return errors.Join(forbiddenErr, err)
+	}
+	return forbiddenErr
+}
+
+// isSessionBoundToChat returns true, if the session activity follows the chat one. Sessions of PerUserSession strategy
+// are shared across all chats with the user, so they are bound to the user private chat only.
+func isSessionBoundToChat(sessionKey SessionKey, chatId int64) bool {
+	return sessionKey.ChatId == chatId || (sessionKey.ChatId == 0 && sessionKey.UserId == chatId)
+}
+
+func (b *BotFsm[T]) runLifecycleHook(ctx context.Context, logger fsmLogger, event LifecycleEvent) error {
+	logger.log(ctx, slog.LevelInfo, "lifecycle event", slog.String("event", string(event.Type)),
+		slog.String("session_key", event.SessionKey.String()))
+	hook := b.lifecycleHooks.hook(event.Type)
+	if hook == nil {
+		return nil
+	}
+	err := hook(ctx, event)
+	if err != nil {
+		return &LifecycleHookError{event.Type, err}
+	}
+	return nil
+}
+
+// getLifecycleEventType returns the event type for the bot membership change. It returns false, if the bot
+// membership hasn't changed.
+func getLifecycleEventType(memberUpdate *tgbotapi.ChatMemberUpdated) (LifecycleEventType, bool) {
+	wasMember, isMember := isChatMember(memberUpdate.OldChatMember), isChatMember(memberUpdate.NewChatMember)
+	switch {
+	case wasMember == isMember:
+		return "", false
+	case memberUpdate.Chat.IsPrivate() && isMember:
+		return UnblockedEvent, true
+	case memberUpdate.Chat.IsPrivate():
+		return BlockedEvent, true
+	case isMember:
+		return AddedToGroupEvent, true
+	default:
+		return RemovedFromGroupEvent, true
+	}
+}
+
+func isChatMember(member tgbotapi.ChatMember) bool {
+	switch member.Status {
+	case "creator", "administrator", "member":
+		return true
+	case "restricted":
+		return member.IsMember
+	default:
+		return false
+	}
+}
@@ -0,0 +1,109 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func chatMemberUpdate(chatId, userId int64, chatType, oldStatus, newStatus string) *tgbotapi.Update {
+	return &tgbotapi.Update{MyChatMember: &tgbotapi.ChatMemberUpdated{
+		Chat:          tgbotapi.Chat{ID: chatId, Type: chatType},
+		From:          tgbotapi.User{ID: userId},
+		OldChatMember: tgbotapi.ChatMember{Status: oldStatus},
+		NewChatMember: tgbotapi.ChatMember{Status: newStatus},
+	}}
+}
+
+func TestInactiveSessionReactivatedByUpdate(t *testing.T) {
+	fake, bot := newFakeTelegram(t)
+	builder := New[int]()
+	builder.State(UndefinedState).Text("start")
+	var events []LifecycleEventType
+	hook := func(ctx context.Context, event LifecycleEvent) error {
+		events = append(events, event.Type)
+		return nil
+	}
+	botFsm := builder.Build(bot, WithLifecycleHooks[int](LifecycleHooks{OnBlocked: hook, OnUnblocked: hook}))
+	ctx := context.Background()
+
+	fake.setErrorCode(http.StatusForbidden)
+	var forbiddenErr *ForbiddenError
+	if err := botFsm.HandleUpdate(ctx, textUpdate(1, "hi")); !errors.As(err, &forbiddenErr) {
+		t.Fatalf("expected ForbiddenError, got %v", err)
+	}
+	fake.setErrorCode(0)
+	if err := botFsm.GoTo(ctx, 1, StateTransition(UndefinedState), 0); err != nil {
+		t.Fatalf("goto error: %s", err)
+	}
+	if texts := fake.sentTexts(); len(texts) != 1 {
+		t.Fatalf("expected goto skipped for inactive session, got %v", texts)
+	}
+	if err := botFsm.HandleUpdate(ctx, textUpdate(1, "hi again")); err != nil {
+		t.Fatalf("update error: %s", err)
+	}
+	if err := botFsm.GoTo(ctx, 1, StateTransition(UndefinedState), 0); err != nil {
+		t.Fatalf("goto error: %s", err)
+	}
+	if texts := fake.sentTexts(); len(texts) != 3 {
+		t.Fatalf("expected goto sent for reactivated session, got %v", texts)
+	}
+	if len(events) != 2 || events[0] != BlockedEvent || events[1] != UnblockedEvent {
+		t.Fatalf("unexpected events: %v", events)
+	}
+}
+
+func TestChatMemberUpdateSessions(t *testing.T) {
+	tests := []struct {
+		name       string
+		strategy   SessionStrategy
+		update     *tgbotapi.Update
+		sessionKey SessionKey
+		inactive   bool
+	}{
+		{"private chat", PerChatSession, chatMemberUpdate(1, 1, "private", "member", "kicked"),
+			SessionKey{ChatId: 1}, true},
+		{"private chat per user", PerUserSession, chatMemberUpdate(1, 1, "private", "member", "kicked"),
+			SessionKey{UserId: 1}, true},
+		{"group", PerChatSession, chatMemberUpdate(-1, 1, "group", "member", "left"),
+			SessionKey{ChatId: -1}, true},
+		{"group per chat user", PerChatUserSession, chatMemberUpdate(-1, 1, "group", "member", "left"),
+			SessionKey{ChatId: -1, UserId: 1}, true},
+		{"group per user", PerUserSession, chatMemberUpdate(-1, 1, "group", "member", "left"),
+			SessionKey{UserId: 1}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, bot := newFakeTelegram(t)
+			builder := New[int]()
+			builder.State(UndefinedState).Text("start")
+			var event LifecycleEvent
+			hook := func(ctx context.Context, e LifecycleEvent) error {
+				event = e
+				return nil
+			}
+			botFsm := builder.Build(bot, WithSessionStrategy[int](test.strategy),
+				WithLifecycleHooks[int](LifecycleHooks{OnBlocked: hook, OnRemovedFromGroup: hook}))
+
+			if err := botFsm.HandleUpdate(context.Background(), test.update); err != nil {
+				t.Fatalf("update error: %s", err)
+			}
+			_, _, meta, err := botFsm.loadState(context.Background(), test.sessionKey)
+			if err != nil {
+				t.Fatalf("load error: %s", err)
+			}
+			if meta.Inactive != test.inactive {
+				t.Fatalf("expected inactive %t, got %t", test.inactive, meta.Inactive)
+			}
+			if test.inactive && event.SessionKey != test.sessionKey {
+				t.Fatalf("expected event session key %v, got %v", test.sessionKey, event.SessionKey)
+			}
+			if !test.inactive && event.SessionKey != (SessionKey{}) {
+				t.Fatalf("expected empty event session key, got %v", event.SessionKey)
+			}
+		})
+	}
+}
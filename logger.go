@@ -0,0 +1,38 @@
+package fsm
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WithLogger enables structured logging of update processing. Routine steps (update received, state loaded, keyboard
+// removed, state saved, messages sent) are logged at debug level, commands and transitions at info level, and errors
+// at error level.
+func WithLogger[T any](logger *slog.Logger) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.logger = fsmLogger{logger}
+	}
+}
+
+// fsmLogger is a nil-safe slog.Logger wrapper. Logging is disabled when the wrapped logger is nil.
+type fsmLogger struct {
+	*slog.Logger
+}
+
+func (l fsmLogger) with(attrs ...slog.Attr) fsmLogger {
+	if l.Logger == nil {
+		return l
+	}
+	args := make([]any, len(attrs))
+	for i, attr := range attrs {
+		args[i] = attr
+	}
+	return fsmLogger{l.Logger.With(args...)}
+}
+
+func (l fsmLogger) log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if l.Logger == nil {
+		return
+	}
+	l.LogAttrs(ctx, level, msg, attrs...)
+}
@@ -0,0 +1,263 @@
+package fsm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Persistence operations reported to Metrics.
+const (
+	LoadStateOperation = "load_state"
+	SaveStateOperation = "save_state"
+	LoadMetaOperation  = "load_meta"
+)
+
+// Metrics receives FSM measurements. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// UpdateHandled is called once HandleUpdate is finished. State is the loaded state, it's empty if state wasn't
+	// loaded.
+	UpdateHandled(updateType UpdateType, state State, duration time.Duration)
+	// TransitionPerformed is called for every saved transition.
+	TransitionPerformed(from State, to State)
+	CommandInvoked(command string)
+	UnknownCommand(command string)
+	// ErrorOccurred is called for every error returned by HandleUpdate or GoTo. Error type is the name of the
+	// first FSM error type found in the returned error, e.g. LoadStateError, or "unknown".
+	ErrorOccurred(errorType string)
+	// SendObserved is called for every request sent to Telegram.
+	SendObserved(duration time.Duration)
+	// PersistenceObserved is called for every persistence handler call. See operation constants.
+	PersistenceObserved(operation string, duration time.Duration)
+}
+
+// WithMetrics sets Metrics which receive FSM measurements. See PrometheusMetrics for the default implementation.
+func WithMetrics[T any](metrics Metrics) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.metrics = metrics
+	}
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) UpdateHandled(UpdateType, State, time.Duration) {}
+func (noopMetrics) TransitionPerformed(State, State)               {}
+func (noopMetrics) CommandInvoked(string)                          {}
+func (noopMetrics) UnknownCommand(string)                          {}
+func (noopMetrics) ErrorOccurred(string)                           {}
+func (noopMetrics) SendObserved(time.Duration)                     {}
+func (noopMetrics) PersistenceObserved(string, time.Duration)      {}
+
+// PrometheusMetrics is a Metrics implementation which keeps counters and histograms in memory and exposes them in
+// Prometheus text format. Use NewPrometheusMetrics to create it.
+type PrometheusMetrics struct {
+	mx       sync.Mutex
+	buckets  []float64
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	name       string
+	help       string
+	histogram  bool
+	labelNames []string
+	series     map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	// Counter value or histogram sum.
+	value        float64
+	count        uint64
+	bucketCounts []uint64
+}
+
+// NewPrometheusMetrics creates PrometheusMetrics. Buckets are histogram upper bounds in seconds. Default buckets are
+// used if none are given.
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	m := &PrometheusMetrics{buckets: buckets, families: make(map[string]*metricFamily)}
+	m.addFamily("updates_total", "Number of handled updates.", false, "type", "state")
+	m.addFamily("update_duration_seconds", "Update handling duration.", true, "type")
+	m.addFamily("transitions_total", "Number of saved transitions.", false, "from", "to")
+	m.addFamily("commands_total", "Number of invoked commands.", false, "command")
+	m.addFamily("unknown_commands_total", "Number of unknown commands.", false)
+	m.addFamily("errors_total", "Number of update handling errors.", false, "type")
+	m.addFamily("send_duration_seconds", "Telegram request duration.", true)
+	m.addFamily("persistence_duration_seconds", "Persistence handler call duration.", true, "operation")
+	return m
+}
+
+func (m *PrometheusMetrics) addFamily(name string, help string, histogram bool, labelNames ...string) {
+	name = "telegram_bot_fsm_" + name
+	m.families[name] = &metricFamily{
+		name:       name,
+		help:       help,
+		histogram:  histogram,
+		labelNames: labelNames,
+		series:     make(map[string]*metricSeries),
+	}
+}
+
+func (m *PrometheusMetrics) UpdateHandled(updateType UpdateType, state State, duration time.Duration) {
+	m.inc("updates_total", string(updateType), state)
+	m.observe("update_duration_seconds", duration, string(updateType))
+}
+
+func (m *PrometheusMetrics) TransitionPerformed(from State, to State) {
+	m.inc("transitions_total", from, to)
+}
+
+func (m *PrometheusMetrics) CommandInvoked(command string) {
+	m.inc("commands_total", command)
+}
+
+// UnknownCommand doesn't keep the command name, since it's an arbitrary user input.
+func (m *PrometheusMetrics) UnknownCommand(string) {
+	m.inc("unknown_commands_total")
+}
+
+func (m *PrometheusMetrics) ErrorOccurred(errorType string) {
+	m.inc("errors_total", errorType)
+}
+
+func (m *PrometheusMetrics) SendObserved(duration time.Duration) {
+	m.observe("send_duration_seconds", duration)
+}
+
+func (m *PrometheusMetrics) PersistenceObserved(operation string, duration time.Duration) {
+	m.observe("persistence_duration_seconds", duration, operation)
+}
+
+func (m *PrometheusMetrics) inc(name string, labelValues ...string) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.getSeries(name, labelValues).value++
+}
+
+func (m *PrometheusMetrics) observe(name string, duration time.Duration, labelValues ...string) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	series := m.getSeries(name, labelValues)
+	value := duration.Seconds()
+	series.value += value
+	series.count++
+	for i, bound := range m.buckets {
+		if value <= bound {
+			series.bucketCounts[i]++
+		}
+	}
+}
+
+func (m *PrometheusMetrics) getSeries(name string, labelValues []string) *metricSeries {
+	family := m.families["telegram_bot_fsm_"+name]
+	key := strings.Join(labelValues, "\xff")
+	series, ok := family.series[key]
+	if !ok {
+		series = &metricSeries{labelValues: labelValues}
+		if family.histogram {
+			series.bucketCounts = make([]uint64, len(m.buckets))
+		}
+		family.series[key] = series
+	}
+	return series
+}
+
+// WriteTo writes all metrics to w in Prometheus text format.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	buf := &bytes.Buffer{}
+	m.mx.Lock()
+	for _, name := range sortedKeys(m.families) {
+		m.families[name].write(buf, m.buckets)
+	}
+	m.mx.Unlock()
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP serves metrics in Prometheus text format, so PrometheusMetrics can be used as a scrape endpoint handler.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+func (f *metricFamily) write(buf *bytes.Buffer, buckets []float64) {
+	metricType := "counter"
+	if f.histogram {
+		metricType = "histogram"
+	}
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, metricType)
+	for _, key := range sortedKeys(f.series) {
+		series := f.series[key]
+		if !f.histogram {
+			fmt.Fprintf(buf, "%s%s %s\n", f.name, f.labels(series, ""), formatFloat(series.value))
+			continue
+		}
+		for i, bound := range buckets {
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, f.labels(series, formatFloat(bound)), series.bucketCounts[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, f.labels(series, "+Inf"), series.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, f.labels(series, ""), formatFloat(series.value))
+		fmt.Fprintf(buf, "%s_count%s %d\n", f.name, f.labels(series, ""), series.count)
+	}
+}
+
+// labels formats series labels. Bucket upper bound is added as "le" label, if it's not empty.
+func (f *metricFamily) labels(series *metricSeries, le string) string {
+	pairs := make([]string, 0, len(f.labelNames)+1)
+	for i, labelName := range f.labelNames {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labelName, escapeLabelValue(series.labelValues[i])))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// getErrorType returns the name of the first FSM error type found in the error tree, e.g. "ForbiddenError" for
+// *ForbiddenError joined with a hook error. The tree is examined in the same order as errors.As does. If there is no
+// FSM error in the tree, "unknown" is returned.
+func getErrorType(err error) string {
+	errType := reflect.TypeOf(err)
+	if errType == nil {
+		return "unknown"
+	}
+	for errType.Kind() == reflect.Ptr {
+		errType = errType.Elem()
+	}
+	if errType.Name() != "" && errType.PkgPath() == reflect.TypeOf(SessionKey{}).PkgPath() {
+		return errType.Name()
+	}
+	switch wrapper := err.(type) {
+	case interface{ Unwrap() error }:
+		return getErrorType(wrapper.Unwrap())
+	case interface{ Unwrap() []error }:
+		for _, wrappedErr := range wrapper.Unwrap() {
+			if wrappedErrType := getErrorType(wrappedErr); wrappedErrType != "unknown" {
+				return wrappedErrType
+			}
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,81 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetricsExposition(t *testing.T) {
+	metrics := NewPrometheusMetrics(1, 0.1)
+	metrics.CommandInvoked("start")
+	metrics.UnknownCommand("random")
+	metrics.UpdateHandled(MessageUpdate, "say \"hi\"\n", 500*time.Millisecond)
+	metrics.SendObserved(50 * time.Millisecond)
+	metrics.SendObserved(2 * time.Second)
+
+	expected := `# HELP telegram_bot_fsm_commands_total Number of invoked commands.
+# TYPE telegram_bot_fsm_commands_total counter
+telegram_bot_fsm_commands_total{command="start"} 1
+# HELP telegram_bot_fsm_errors_total Number of update handling errors.
+# TYPE telegram_bot_fsm_errors_total counter
+# HELP telegram_bot_fsm_persistence_duration_seconds Persistence handler call duration.
+# TYPE telegram_bot_fsm_persistence_duration_seconds histogram
+# HELP telegram_bot_fsm_send_duration_seconds Telegram request duration.
+# TYPE telegram_bot_fsm_send_duration_seconds histogram
+telegram_bot_fsm_send_duration_seconds_bucket{le="0.1"} 1
+telegram_bot_fsm_send_duration_seconds_bucket{le="1"} 1
+telegram_bot_fsm_send_duration_seconds_bucket{le="+Inf"} 2
+telegram_bot_fsm_send_duration_seconds_sum 2.05
+telegram_bot_fsm_send_duration_seconds_count 2
+# HELP telegram_bot_fsm_transitions_total Number of saved transitions.
+# TYPE telegram_bot_fsm_transitions_total counter
+# HELP telegram_bot_fsm_unknown_commands_total Number of unknown commands.
+# TYPE telegram_bot_fsm_unknown_commands_total counter
+telegram_bot_fsm_unknown_commands_total 1
+# HELP telegram_bot_fsm_update_duration_seconds Update handling duration.
+# TYPE telegram_bot_fsm_update_duration_seconds histogram
+telegram_bot_fsm_update_duration_seconds_bucket{type="message",le="0.1"} 0
+telegram_bot_fsm_update_duration_seconds_bucket{type="message",le="1"} 1
+telegram_bot_fsm_update_duration_seconds_bucket{type="message",le="+Inf"} 1
+telegram_bot_fsm_update_duration_seconds_sum{type="message"} 0.5
+telegram_bot_fsm_update_duration_seconds_count{type="message"} 1
+# HELP telegram_bot_fsm_updates_total Number of handled updates.
+# TYPE telegram_bot_fsm_updates_total counter
+telegram_bot_fsm_updates_total{type="message",state="say \"hi\"\n"} 1
+`
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %s", contentType)
+	}
+	if actual := recorder.Body.String(); actual != expected {
+		t.Fatalf("unexpected exposition:\n%s", actual)
+	}
+}
+
+func TestGetErrorType(t *testing.T) {
+	hookErr := &EnterStateError{State: "menu", Err: errors.New("hook failed")}
+	tests := []struct {
+		name      string
+		err       error
+		errorType string
+	}{
+		{"fsm error", &LoadStateError{errors.New("db is down")}, "LoadStateError"},
+		{"wrapped fsm error", fmt.Errorf("handling: %w", hookErr), "EnterStateError"},
+		{"joined fsm errors", errors.Join(&ForbiddenError{ChatId: 1}, hookErr), "ForbiddenError"},
+		{"joined with other error", errors.Join(context.Canceled, hookErr), "EnterStateError"},
+		{"other error", fmt.Errorf("handling: %w", context.Canceled), "unknown"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if errorType := getErrorType(test.err); errorType != test.errorType {
+				t.Fatalf("expected %s, got %s", test.errorType, errorType)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package fsm
+
+import (
+	"context"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// UpdateContext contains information about the update being processed by HandleUpdate.
+type UpdateContext[T any] struct {
+	Update *tgbotapi.Update
+	ChatId int64
+	// Forum topic id the update came from. It's 0 unless update is passed to HandleRawUpdate.
+	ThreadId int
+	// Data sent by the web app. It's nil unless update is a web app data message passed to HandleRawUpdate.
+	WebAppData *WebAppData
+	SessionKey SessionKey
+	// Loaded state and data. Middleware may change them before calling the next handler.
+	State State
+	Data  T
+	// Command name without "/" prefix. It's empty if update is not a command.
+	Command string
+	// Transition result. These fields are populated once the next state is chosen and hooks are called, so they are
+	// available to middleware after the next handler returns. NewState is never empty, even if Transition State is,
+	// unless update is ignored by the state (see UpdateTypeHandler).
+	Transition Transition
+	NewState   State
+	NewData    T
+	// Loaded meta.
+	meta Meta[T]
+	// Logger with update attributes.
+	logger fsmLogger
+	// Messages sent to the chat.
+	messages []tgbotapi.MessageConfig
+}
+
+// Handler processes the update within UpdateContext.
+type Handler[T any] func(ctx context.Context, updateContext *UpdateContext[T]) error
+
+// Middleware wraps Handler to add some cross-cutting logic, e.g. logging, auth or metrics. Middleware may skip the
+// update by not calling the next handler.
+type Middleware[T any] func(next Handler[T]) Handler[T]
+
+// Use adds middlewares to the chain around HandleUpdate. The first added middleware is the outermost one. Middlewares
+// are called after the state is loaded. Use is not safe for concurrent use with HandleUpdate, so it should be called
+// during bot initialization.
+func (b *BotFsm[T]) Use(middlewares ...Middleware[T]) {
+	b.middlewares = append(b.middlewares, middlewares...)
+}
+
+func (b *BotFsm[T]) chain(handler Handler[T]) Handler[T] {
+	for i := len(b.middlewares) - 1; i >= 0; i-- {
+		handler = b.middlewares[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,109 @@
+package fsm
+
+import (
+	"context"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func middlewareBuilder() *Builder[int] {
+	builder := New[int]()
+	builder.State(UndefinedState).Text("start").
+		OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+			return StateTransition("next"), data + 1
+		})
+	builder.State("next").
+		Message(func(ctx context.Context, data int) MessageConfig {
+			return TextMessageConfig("next")
+		}).
+		OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+			return StateTransition(UndefinedState), data * 10
+		})
+	return builder
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	_, bot := newFakeTelegram(t)
+	botFsm := middlewareBuilder().Build(bot)
+	var calls []string
+	trace := func(name string) Middleware[int] {
+		return func(next Handler[int]) Handler[int] {
+			return func(ctx context.Context, updateContext *UpdateContext[int]) error {
+				calls = append(calls, name+" before")
+				err := next(ctx, updateContext)
+				calls = append(calls, name+" after")
+				return err
+			}
+		}
+	}
+	botFsm.Use(trace("first"), trace("second"))
+	botFsm.Use(trace("third"))
+
+	if err := botFsm.HandleUpdate(context.Background(), textUpdate(1, "hi")); err != nil {
+		t.Fatalf("update error: %s", err)
+	}
+	expected := []string{"first before", "second before", "third before", "third after", "second after", "first after"}
+	if len(calls) != len(expected) {
+		t.Fatalf("expected calls %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("expected calls %v, got %v", expected, calls)
+		}
+	}
+}
+
+func TestMiddlewareChangesStateAndData(t *testing.T) {
+	_, bot := newFakeTelegram(t)
+	botFsm := middlewareBuilder().Build(bot)
+	var result *UpdateContext[int]
+	botFsm.Use(func(next Handler[int]) Handler[int] {
+		return func(ctx context.Context, updateContext *UpdateContext[int]) error {
+			updateContext.State, updateContext.Data = "next", 5
+			err := next(ctx, updateContext)
+			result = updateContext
+			return err
+		}
+	})
+	ctx := context.Background()
+
+	if err := botFsm.HandleUpdate(ctx, textUpdate(1, "hi")); err != nil {
+		t.Fatalf("update error: %s", err)
+	}
+	if result.NewState != UndefinedState || result.NewData != 50 || result.Transition.State != UndefinedState {
+		t.Fatalf("unexpected transition result: %s %d %+v", result.NewState, result.NewData, result.Transition)
+	}
+	state, data, _, err := botFsm.loadState(ctx, SessionKey{ChatId: 1})
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	if state != UndefinedState || data != 50 {
+		t.Fatalf("expected state %s and data 50 saved, got %s and %d", UndefinedState, state, data)
+	}
+}
+
+func TestMiddlewareSkipsUpdate(t *testing.T) {
+	fake, bot := newFakeTelegram(t)
+	botFsm := middlewareBuilder().Build(bot)
+	botFsm.Use(func(next Handler[int]) Handler[int] {
+		return func(ctx context.Context, updateContext *UpdateContext[int]) error {
+			return nil
+		}
+	})
+	ctx := context.Background()
+
+	if err := botFsm.HandleUpdate(ctx, textUpdate(1, "hi")); err != nil {
+		t.Fatalf("update error: %s", err)
+	}
+	if texts := fake.sentTexts(); len(texts) != 0 {
+		t.Fatalf("expected nothing sent, got %v", texts)
+	}
+	state, _, _, err := botFsm.loadState(ctx, SessionKey{ChatId: 1})
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	if state != UndefinedState {
+		t.Fatalf("expected state not changed, got %s", state)
+	}
+}
@@ -0,0 +1,215 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// PaymentQueryError Error wrapper for PreCheckoutQueryFn and ShippingQueryFn error. The query is rejected with the
+// payment error text.
+type PaymentQueryError struct {
+	UpdateType
+	Err error
+}
+
+func (e *PaymentQueryError) Error() string {
+	return fmt.Sprintf("%s error: %s", e.UpdateType, e.Err)
+}
+
+func (e *PaymentQueryError) Unwrap() error {
+	return e.Err
+}
+
+// PreCheckoutQueryHandler confirms orders right before the payment.
+type PreCheckoutQueryHandler[T any] interface {
+	// PreCheckoutQueryFn returns the error message shown to the user if the order is rejected, or empty string if it's
+	// confirmed. State and data belong to the session of the user private chat with the bot.
+	PreCheckoutQueryFn(
+		ctx context.Context,
+		query *tgbotapi.PreCheckoutQuery,
+		state State,
+		data T,
+	) (errorMessage string, err error)
+}
+
+// ShippingQueryHandler provides shipping options for invoices with flexible price.
+type ShippingQueryHandler[T any] interface {
+	// ShippingQueryFn returns shipping options available for the address, or the error message shown to the user if
+	// delivery is impossible. State and data belong to the session of the user private chat with the bot.
+	ShippingQueryFn(
+		ctx context.Context,
+		query *tgbotapi.ShippingQuery,
+		state State,
+		data T,
+	) (options []tgbotapi.ShippingOption, errorMessage string, err error)
+}
+
+// SuccessfulPaymentHandler may be implemented by StateHandler to handle successful payment service messages instead
+// of TransitionFn.
+type SuccessfulPaymentHandler[T any] interface {
+	SuccessfulPaymentFn(ctx context.Context, payment *tgbotapi.SuccessfulPayment, data T) (Transition, T)
+}
+
+func WithPreCheckoutQueryHandler[T any](handler PreCheckoutQueryHandler[T]) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.preCheckoutQueryHandler = handler
+	}
+}
+
+func WithShippingQueryHandler[T any](handler ShippingQueryHandler[T]) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.shippingQueryHandler = handler
+	}
+}
+
+// WithPaymentProviderToken sets the provider token of invoices which don't have it.
+func WithPaymentProviderToken[T any](token string) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.paymentProviderToken = token
+	}
+}
+
+// WithPaymentTimeout sets the time given to answer pre-checkout and shipping queries. Telegram waits for the answer
+// for 10 seconds. If the handler doesn't return in time, its context is canceled and the query is rejected.
+func WithPaymentTimeout[T any](timeout time.Duration) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.paymentTimeout = timeout
+	}
+}
+
+// WithPaymentErrorText sets the error message shown to the user when a payment query handler fails or times out.
+func WithPaymentErrorText[T any](text string) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.paymentErrorText = text
+	}
+}
+
+// InvoiceMessageConfig simplifies MessageConfig creation for invoices. Provider token is set by
+// WithPaymentProviderToken option, other invoice fields can be set via Invoice field.
+func InvoiceMessageConfig(
+	title, description, payload, currency string,
+	prices ...tgbotapi.LabeledPrice,
+) MessageConfig {
+	return MessageConfig{Invoice: &tgbotapi.InvoiceConfig{
+		Title:       title,
+		Description: description,
+		Payload:     payload,
+		Currency:    currency,
+		Prices:      prices,
+		// tgbotapi sends nil slice as null, which Telegram rejects.
+		SuggestedTipAmounts: []int{},
+	}}
+}
+
+// getInvoiceConfig returns the invoice to send to the chat. It's nil if message has no invoice.
+func (b *BotFsm[T]) getInvoiceConfig(chatId int64, messageConfig MessageConfig) *tgbotapi.InvoiceConfig {
+	if messageConfig.Invoice == nil {
+		return nil
+	}
+	invoice := *messageConfig.Invoice
+	invoice.ChatID = chatId
+	if invoice.ProviderToken == "" {
+		invoice.ProviderToken = b.paymentProviderToken
+	}
+	return &invoice
+}
+
+func (b *BotFsm[T]) answerPreCheckoutQuery(ctx context.Context, update *tgbotapi.Update) error {
+	query := update.PreCheckoutQuery
+	if query.From == nil {
+		return &NoUserIdError{update}
+	}
+	errorMessage, err := callWithTimeout(ctx, b.paymentTimeout, func(ctx context.Context) (string, error) {
+		state, data, _, err := b.loadState(ctx, b.getPrivateSessionKey(query.From.ID))
+		if err != nil {
+			return "", err
+		}
+		return b.preCheckoutQueryHandler.PreCheckoutQueryFn(ctx, query, state, data)
+	})
+	answer := tgbotapi.PreCheckoutConfig{
+		PreCheckoutQueryID: query.ID,
+		OK:                 errorMessage == "",
+		ErrorMessage:       errorMessage,
+	}
+	if err != nil {
+		answer.OK, answer.ErrorMessage = false, b.paymentErrorText
+		err = &PaymentQueryError{PreCheckoutQueryUpdate, err}
+	}
+	_, sendErr := b.request(ctx, answer)
+	return errors.Join(err, sendErr)
+}
+
+func (b *BotFsm[T]) answerShippingQuery(ctx context.Context, update *tgbotapi.Update) error {
+	query := update.ShippingQuery
+	if query.From == nil {
+		return &NoUserIdError{update}
+	}
+	answer, err := callWithTimeout(ctx, b.paymentTimeout, func(ctx context.Context) (tgbotapi.ShippingConfig, error) {
+		answer := tgbotapi.ShippingConfig{ShippingQueryID: query.ID}
+		state, data, _, err := b.loadState(ctx, b.getPrivateSessionKey(query.From.ID))
+		if err != nil {
+			return answer, err
+		}
+		answer.ShippingOptions, answer.ErrorMessage, err = b.shippingQueryHandler.ShippingQueryFn(ctx, query, state, data)
+		answer.OK = answer.ErrorMessage == ""
+		return answer, err
+	})
+	if err != nil {
+		answer = tgbotapi.ShippingConfig{ShippingQueryID: query.ID, ErrorMessage: b.paymentErrorText}
+		err = &PaymentQueryError{ShippingQueryUpdate, err}
+	}
+	_, sendErr := b.request(ctx, answer)
+	return errors.Join(err, sendErr)
+}
+
+// callWithTimeout calls fn in a separate goroutine and returns context.DeadlineExceeded, if it doesn't return in
+// time. fn context is canceled on timeout, but fn may keep running, so it should be called on a snapshot. Panic in fn
+// is returned as PanicError.
+func callWithTimeout[R any](
+	ctx context.Context,
+	timeout time.Duration,
+	fn func(ctx context.Context) (R, error),
+) (R, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	type result struct {
+		value R
+		err   error
+	}
+	// Buffered, so the goroutine doesn't leak when the result is not awaited anymore.
+	results := make(chan result, 1)
+	go func() {
+		defer func() {
+			if value := recover(); value != nil {
+				results <- result{err: &PanicError{Value: value, Stack: debug.Stack()}}
+			}
+		}()
+		value, err := fn(ctx)
+		results <- result{value, err}
+	}()
+	select {
+	case res := <-results:
+		return res.value, res.err
+	case <-ctx.Done():
+		var empty R
+		return empty, ctx.Err()
+	}
+}
+
+// successfulPaymentProvider passes successful payment messages to SuccessfulPaymentFn.
+type successfulPaymentProvider[T any] struct {
+	handler SuccessfulPaymentHandler[T]
+}
+
+func (p *successfulPaymentProvider[T]) TransitionFn(
+	ctx context.Context,
+	update *tgbotapi.Update,
+	data T,
+) (Transition, T) {
+	return p.handler.SuccessfulPaymentFn(ctx, update.Message.SuccessfulPayment, data)
+}
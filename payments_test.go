@@ -0,0 +1,50 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type slowPreCheckoutHandler struct {
+	done chan struct{}
+}
+
+func (h slowPreCheckoutHandler) PreCheckoutQueryFn(
+	ctx context.Context,
+	query *tgbotapi.PreCheckoutQuery,
+	state State,
+	data int,
+) (string, error) {
+	defer close(h.done)
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestPreCheckoutQueryTimeoutWithReload(t *testing.T) {
+	fake, bot := newFakeTelegram(t)
+	builder := New[int]()
+	builder.State(UndefinedState).Text("start")
+	handler := slowPreCheckoutHandler{done: make(chan struct{})}
+	botFsm := builder.Build(bot, WithPreCheckoutQueryHandler[int](handler),
+		WithPaymentTimeout[int](10*time.Millisecond), WithMissingStateFallback[int](UndefinedState))
+	configs, commands := builder.Configs()
+
+	update := &tgbotapi.Update{PreCheckoutQuery: &tgbotapi.PreCheckoutQuery{ID: "q", From: &tgbotapi.User{ID: 1}}}
+	err := botFsm.HandleUpdate(context.Background(), update)
+	var paymentErr *PaymentQueryError
+	if !errors.As(err, &paymentErr) {
+		t.Fatalf("expected PaymentQueryError, got %v", err)
+	}
+	if err = botFsm.Reload(configs, commands); err != nil {
+		t.Fatalf("reload error: %s", err)
+	}
+	<-handler.done
+	answers := fake.sent("answerPreCheckoutQuery")
+	if len(answers) != 1 || answers[0].Params.Get("ok") != "" {
+		t.Fatalf("expected rejection, got %v", answers)
+	}
+}
@@ -0,0 +1,221 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TriggerKind describes what caused a transition.
+type TriggerKind string
+
+const (
+	MessageTrigger       TriggerKind = "message"
+	CallbackQueryTrigger TriggerKind = "callback_query"
+	CommandTrigger       TriggerKind = "command"
+	GoToTrigger          TriggerKind = "goto"
+	// Transition caused by an update of another type, e.g. edited message or chat member update.
+	OtherUpdateTrigger TriggerKind = "other_update"
+)
+
+// TransitionEvent describes a performed transition.
+type TransitionEvent struct {
+	ChatId     int64
+	SessionKey SessionKey
+	// The state chat was in before the transition. For commands, it's the state before reset to UndefinedState.
+	From State
+	To   State
+	TriggerKind
+	// Command name without "/" prefix. It's empty unless TriggerKind is CommandTrigger.
+	Command string
+}
+
+// TransitionObserver is notified about every transition saved by HandleUpdate or GoTo, including transitions to the
+// same state.
+type TransitionObserver interface {
+	ObserveTransition(ctx context.Context, event TransitionEvent)
+}
+
+func WithTransitionObserver[T any](observer TransitionObserver) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.transitionObservers = append(opts.transitionObservers, observer)
+	}
+}
+
+func (b *BotFsm[T]) notifyTransition(ctx context.Context, event TransitionEvent) {
+	b.metrics.TransitionPerformed(event.From, event.To)
+	for _, observer := range b.transitionObservers {
+		observer.ObserveTransition(ctx, event)
+	}
+}
+
+func getTriggerKind(update *tgbotapi.Update, command string) TriggerKind {
+	if command != "" {
+		return CommandTrigger
+	}
+	switch getUpdateType(update) { //nolint:exhaustive // other types are covered by default
+	case MessageUpdate:
+		return MessageTrigger
+	case CallbackQueryUpdate:
+		return CallbackQueryTrigger
+	default:
+		return OtherUpdateTrigger
+	}
+}
+
+// ObservedEdge describes a transition observed by TransitionRecorder.
+type ObservedEdge struct {
+	From        State `json:"from"`
+	To          State `json:"to"`
+	TriggerKind `json:"trigger"`
+	// Command name for CommandTrigger edges.
+	Command string `json:"command,omitempty"`
+	Count   int64  `json:"count"`
+}
+
+type observedEdgeKey struct {
+	from        State
+	to          State
+	triggerKind TriggerKind
+	command     string
+}
+
+// TransitionRecorder is a TransitionObserver which counts observed transitions. It helps to discover the real state
+// graph, find dead states and unexpected jumps. Use NewTransitionRecorder to create it.
+type TransitionRecorder struct {
+	mx     sync.Mutex
+	counts map[observedEdgeKey]int64
+}
+
+func NewTransitionRecorder() *TransitionRecorder {
+	return &TransitionRecorder{counts: make(map[observedEdgeKey]int64)}
+}
+
+func (r *TransitionRecorder) ObserveTransition(ctx context.Context, event TransitionEvent) {
+	r.add(observedEdgeKey{
+		from:        event.From,
+		to:          event.To,
+		triggerKind: event.TriggerKind,
+		command:     event.Command,
+	}, 1)
+}
+
+func (r *TransitionRecorder) add(key observedEdgeKey, count int64) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.counts[key] += count
+}
+
+// Edges returns all observed edges sorted by source state, target state and trigger.
+func (r *TransitionRecorder) Edges() []ObservedEdge {
+	r.mx.Lock()
+	edges := make([]ObservedEdge, 0, len(r.counts))
+	for key, count := range r.counts {
+		edges = append(edges, ObservedEdge{
+			From:        key.from,
+			To:          key.to,
+			TriggerKind: key.triggerKind,
+			Command:     key.command,
+			Count:       count,
+		})
+	}
+	r.mx.Unlock()
+
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		if a.TriggerKind != b.TriggerKind {
+			return a.TriggerKind < b.TriggerKind
+		}
+		return a.Command < b.Command
+	})
+	return edges
+}
+
+// Graph builds the observed graph. Edges are labeled with the trigger and the number of observations.
+func (r *TransitionRecorder) Graph() *Graph {
+	observedEdges := r.Edges()
+	edges := make([]GraphEdge, len(observedEdges))
+	for i, observedEdge := range observedEdges {
+		trigger := string(observedEdge.TriggerKind)
+		if observedEdge.TriggerKind == CommandTrigger {
+			trigger = "/" + observedEdge.Command
+		}
+		edges[i] = GraphEdge{
+			From:  observedEdge.From,
+			To:    observedEdge.To,
+			Label: fmt.Sprintf("%s (%d)", trigger, observedEdge.Count),
+		}
+	}
+	return newGraph(nil, edges)
+}
+
+// UnvisitedStates returns states from the given list which were never entered or left.
+func (r *TransitionRecorder) UnvisitedStates(states []State) []State {
+	visited := make(map[State]struct{})
+	for _, edge := range r.Edges() {
+		visited[edge.From] = struct{}{}
+		visited[edge.To] = struct{}{}
+	}
+	var unvisited []State
+	for _, state := range states {
+		if _, ok := visited[state]; !ok {
+			unvisited = append(unvisited, state)
+		}
+	}
+	return unvisited
+}
+
+// UndeclaredEdges returns observed transitions between different states which are not present in the given graph
+// (e.g. the one returned by BotFsm Graph). Global graph edges match transitions from any state.
+func (r *TransitionRecorder) UndeclaredEdges(graph *Graph) []ObservedEdge {
+	declared := make(map[[2]State]struct{}, len(graph.Edges))
+	for _, edge := range graph.Edges {
+		declared[[2]State{edge.From, edge.To}] = struct{}{}
+	}
+	var undeclared []ObservedEdge
+	for _, edge := range r.Edges() {
+		if edge.From == edge.To {
+			continue
+		}
+		_, ok := declared[[2]State{edge.From, edge.To}]
+		_, okGlobal := declared[[2]State{AnyState, edge.To}]
+		if !ok && !okGlobal {
+			undeclared = append(undeclared, edge)
+		}
+	}
+	return undeclared
+}
+
+// Save writes observed edges to w as JSON.
+func (r *TransitionRecorder) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r.Edges())
+}
+
+// Load reads edges previously written by Save and adds them to the observed ones.
+func (r *TransitionRecorder) Load(reader io.Reader) error {
+	var edges []ObservedEdge
+	err := json.NewDecoder(reader).Decode(&edges)
+	if err != nil {
+		return err
+	}
+	for _, edge := range edges {
+		r.add(observedEdgeKey{
+			from:        edge.From,
+			to:          edge.To,
+			triggerKind: edge.TriggerKind,
+			command:     edge.Command,
+		}, edge.Count)
+	}
+	return nil
+}
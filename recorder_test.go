@@ -0,0 +1,67 @@
+package fsm
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func recordedTransitions() *TransitionRecorder {
+	recorder := NewTransitionRecorder()
+	ctx := context.Background()
+	events := []TransitionEvent{
+		{From: UndefinedState, To: "menu", TriggerKind: MessageTrigger},
+		{From: UndefinedState, To: "menu", TriggerKind: MessageTrigger},
+		{From: "menu", To: "menu", TriggerKind: CallbackQueryTrigger},
+		{From: "menu", To: "help", TriggerKind: CommandTrigger, Command: "help"},
+		{From: "menu", To: "settings", TriggerKind: GoToTrigger},
+	}
+	for _, event := range events {
+		recorder.ObserveTransition(ctx, event)
+	}
+	return recorder
+}
+
+func TestTransitionRecorderSaveLoad(t *testing.T) {
+	recorder := recordedTransitions()
+	var buf bytes.Buffer
+	if err := recorder.Save(&buf); err != nil {
+		t.Fatalf("save error: %s", err)
+	}
+	loaded := NewTransitionRecorder()
+	if err := loaded.Load(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	if !reflect.DeepEqual(loaded.Edges(), recorder.Edges()) {
+		t.Fatalf("expected edges %v, got %v", recorder.Edges(), loaded.Edges())
+	}
+
+	// Loaded counts are added to the observed ones.
+	if err := recorder.Load(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	edges := recorder.Edges()
+	if len(edges) != 4 || edges[3].From != UndefinedState || edges[3].Count != 4 {
+		t.Fatalf("expected counts summed, got %v", edges)
+	}
+}
+
+func TestTransitionRecorderUndeclaredEdges(t *testing.T) {
+	graph := &Graph{Edges: []GraphEdge{
+		{From: UndefinedState, To: "menu"},
+		{From: AnyState, To: "help", Label: "/help"},
+	}}
+
+	undeclared := recordedTransitions().UndeclaredEdges(graph)
+	if len(undeclared) != 1 || undeclared[0].From != "menu" || undeclared[0].To != "settings" {
+		t.Fatalf("expected menu -> settings edge undeclared, got %v", undeclared)
+	}
+}
+
+func TestTransitionRecorderUnvisitedStates(t *testing.T) {
+	unvisited := recordedTransitions().UnvisitedStates([]State{UndefinedState, "menu", "help", "about", "contacts"})
+	if !reflect.DeepEqual(unvisited, []State{"about", "contacts"}) {
+		t.Fatalf("expected about and contacts unvisited, got %v", unvisited)
+	}
+}
@@ -0,0 +1,92 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError Returned when a panic happened during update processing, e.g. in TransitionFn or MessageFn.
+type PanicError struct {
+	Value any
+	// Stack trace of the goroutine at the moment of panic.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic during update processing: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it's an error.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// WithPanicMessageConfigProvider sets the message which is sent to the chat when panic happens. It receives the
+// loaded chat data.
+func WithPanicMessageConfigProvider[T any](messageConfig MessageConfigProvider[T]) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.panicMessageConfigProvider = messageConfig
+	}
+}
+
+// WithPanicState sets the state chat is switched to when panic happens. If panic message is not set, the state
+// MessageFn is used to get the message.
+func WithPanicState[T any](state State) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.panicState = state
+	}
+}
+
+// handlePanic converts recovered panic value into PanicError and performs configured reaction: switches chat to the
+// panic state, clearing the call stack, and sends the panic message. Errors happened during the reaction are ignored, since PanicError is more
+// important.
+func (b *BotFsm[T]) handlePanic(
+	ctx context.Context,
+	sessionKey SessionKey,
+	chatId int64,
+	threadId int,
+	data T,
+	value any,
+) (err error) {
+	panicErr := &PanicError{Value: value, Stack: debug.Stack()}
+	err = panicErr
+	if chatId == 0 {
+		return panicErr
+	}
+	// Reaction itself calls user code, which may panic again. Nested panic is ignored in favor of the original one.
+	defer func() {
+		_ = recover()
+	}()
+
+	var messageFn MessageFn[T]
+	if b.panicMessageConfigProvider != nil {
+		messageFn = b.panicMessageConfigProvider.MessageFn
+	}
+	if panicStateHandler, ok := b.configs[b.panicState]; ok && b.panicState != "" {
+		meta, metaErr := b.loadMeta(ctx, sessionKey)
+		if metaErr != nil {
+			return panicErr
+		}
+		// The panic aborts sub-flows, so the bot must not return to their callers later.
+		meta.Stack = nil
+		if b.saveState(ctx, sessionKey, b.panicState, data, meta) != nil {
+			return panicErr
+		}
+		if messageFn == nil {
+			messageFn = panicStateHandler.MessageFn
+		}
+	}
+	if messageFn == nil {
+		return panicErr
+	}
+	for _, msgConfig := range b.getStateMessageConfigs(chatId, messageFn(ctx, data)) {
+		if _, sendErr := b.send(ctx, msgConfig, threadId); sendErr != nil {
+			return panicErr
+		}
+	}
+	return panicErr
+}
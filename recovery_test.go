@@ -0,0 +1,67 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	tests := []struct {
+		name   string
+		optFns []BotFsmOptsFn[int]
+		state  State
+		texts  []string
+	}{
+		{"no reaction", nil, "sub", []string{"sub"}},
+		{"panic state", []BotFsmOptsFn[int]{WithPanicState[int]("oops")}, "oops", []string{"sub", "oops"}},
+		{"panic state with meta persistence", []BotFsmOptsFn[int]{
+			WithPanicState[int]("oops"),
+			WithPersistenceHandler[int](newMetaPersistenceHandler()),
+		}, "oops", []string{"sub", "oops"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, bot := newFakeTelegram(t)
+			builder := New[int]()
+			builder.State(UndefinedState).Text("start").
+				OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+					return CallTransition("sub", ""), data
+				})
+			builder.State("sub").Text("sub").
+				OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+					panic("broken")
+				})
+			builder.State("oops").Text("oops")
+			botFsm := builder.Build(bot, test.optFns...)
+			ctx := context.Background()
+
+			if err := botFsm.HandleUpdate(ctx, textUpdate(1, "go")); err != nil {
+				t.Fatalf("call error: %s", err)
+			}
+			var panicErr *PanicError
+			if err := botFsm.HandleUpdate(ctx, textUpdate(1, "hi")); !errors.As(err, &panicErr) {
+				t.Fatalf("expected PanicError, got %v", err)
+			}
+			if panicErr.Value != "broken" || len(panicErr.Stack) == 0 {
+				t.Fatalf("unexpected panic error: %+v", panicErr)
+			}
+			state, _, meta, err := botFsm.loadState(ctx, SessionKey{ChatId: 1})
+			if err != nil {
+				t.Fatalf("load error: %s", err)
+			}
+			if state != test.state {
+				t.Fatalf("expected state %s, got %s", test.state, state)
+			}
+			if test.state == "oops" && len(meta.Stack) != 0 {
+				t.Fatalf("expected call stack cleared, got %v", meta.Stack)
+			}
+			texts := fake.sentTexts()
+			if len(texts) != len(test.texts) || texts[len(texts)-1] != test.texts[len(test.texts)-1] {
+				t.Fatalf("expected messages %v, got %v", test.texts, texts)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package fsm
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// WithMissingStateFallback sets the state used instead of the loaded one when the latter doesn't exist in the current
+// configuration (e.g. it was removed by Reload). Without fallback, HandleUpdate returns
+// CurrentStateConfigNotFoundError in this case. Sub-flow return states are replaced with fallback as well, while
+// history entries of nonexistent states are dropped.
+func WithMissingStateFallback[T any](state State) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.missingStateFallback = state
+	}
+}
+
+// Reload validates the new states and commands configuration and atomically replaces the current one. If
+// configuration has errors, ValidationError is returned and the current configuration is kept. Updates being
+// processed at the moment are finished with the old configuration.
+func (b *BotFsm[T]) Reload(configs map[State]StateHandler[T], commands map[string]TransitionProvider[T]) error {
+	candidate := b.snapshot()
+	candidate.configs = configs
+	candidate.commands = commands
+	report := candidate.Validate()
+	if report.HasErrors() {
+		return &ValidationError{report}
+	}
+
+	b.configsMx.Lock()
+	defer b.configsMx.Unlock()
+	b.configs = configs
+	b.commands = commands
+	return nil
+}
+
+// snapshot returns FSM copy sharing everything with b, but the configuration, which is fixed at the moment of the
+// call. Updates and GoTo calls are processed on snapshots, so configsMx is not held while handlers and Telegram
+// requests are running: handlers may call GoTo, and Reload doesn't wait for updates being processed.
+func (b *BotFsm[T]) snapshot() *BotFsm[T] {
+	b.configsMx.RLock()
+	defer b.configsMx.RUnlock()
+	return &BotFsm[T]{
+		bot:         b.bot,
+		configs:     b.configs,
+		botFsmOpts:  b.botFsmOpts,
+		metaHandler: b.metaHandler,
+		middlewares: b.middlewares,
+	}
+}
+
+// ReloadDefinitionFile loads definition from file and reloads configuration with it. See DefinitionConfigs for
+// handlers description. Go commandHandlers (e.g. BackCommandHandler) are merged into definition commands and take
+// precedence over ones with the same name, so commands which are not in the definition are kept.
+func (b *BotFsm[T]) ReloadDefinitionFile(
+	path string,
+	handlers map[State]StateHandler[T],
+	commandHandlers map[string]TransitionProvider[T],
+) error {
+	definition, err := LoadDefinitionFile(path)
+	if err != nil {
+		return err
+	}
+	configs, commands := DefinitionConfigs(definition, handlers)
+	for command, commandHandler := range commandHandlers {
+		commands[command] = commandHandler
+	}
+	return b.Reload(configs, commands)
+}
+
+// WatchDefinitionFile checks definition file modification time every interval and reloads configuration when the
+// file is changed. See ReloadDefinitionFile for handlers description. Reload errors are passed to onError, if it's not
+// nil. It blocks until ctx is done.
+func (b *BotFsm[T]) WatchDefinitionFile(
+	ctx context.Context,
+	path string,
+	interval time.Duration,
+	handlers map[State]StateHandler[T],
+	commandHandlers map[string]TransitionProvider[T],
+	onError func(err error),
+) error {
+	var modTime time.Time
+	if fileInfo, err := os.Stat(path); err == nil {
+		modTime = fileInfo.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			fileInfo, err := os.Stat(path)
+			if err == nil && fileInfo.ModTime().Equal(modTime) {
+				continue
+			}
+			if err == nil {
+				modTime = fileInfo.ModTime()
+				err = b.ReloadDefinitionFile(path, handlers, commandHandlers)
+			}
+			if err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// applyMissingStateFallback replaces nonexistent states in the loaded state and meta with fallback state.
+func (b *BotFsm[T]) applyMissingStateFallback(state State, meta Meta[T]) (State, Meta[T]) {
+	if b.missingStateFallback == "" {
+		return state, meta
+	}
+	if _, ok := b.configs[state]; !ok {
+		state = b.missingStateFallback
+	}
+	var stack []StackFrame
+	for _, frame := range meta.Stack {
+		if _, ok := b.configs[frame.ReturnState]; !ok {
+			frame.ReturnState = b.missingStateFallback
+		}
+		stack = append(stack, frame)
+	}
+	meta.Stack = stack
+	var history []HistoryEntry[T]
+	for _, entry := range meta.History {
+		if _, ok := b.configs[entry.State]; ok {
+			history = append(history, entry)
+		}
+	}
+	meta.History = history
+	return state, meta
+}
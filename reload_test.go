@@ -0,0 +1,39 @@
+package fsm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadDefinitionFileKeepsGoCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.yaml")
+	definition := `
+states:
+  undefined:
+    text: start
+  menu:
+    text: menu
+commands:
+  start: menu
+`
+	if err := os.WriteFile(path, []byte(definition), 0o600); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	commandHandlers := map[string]TransitionProvider[int]{"back": BackCommandHandler[int]{}}
+	loaded, err := LoadDefinitionFile(path)
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	configs, commands := DefinitionConfigs[int](loaded, nil)
+	commands["back"] = BackCommandHandler[int]{}
+	botFsm := NewBotFsm(nil, configs, WithCommands[int](commands), WithHistory[int](5))
+
+	if err = botFsm.ReloadDefinitionFile(path, nil, commandHandlers); err != nil {
+		t.Fatalf("reload error: %s", err)
+	}
+	reloaded := botFsm.snapshot().commands
+	if len(reloaded) != 2 || reloaded["start"] == nil || reloaded["back"] == nil {
+		t.Fatalf("expected start and back commands, got %v", reloaded)
+	}
+}
@@ -0,0 +1,217 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// NoUserIdError Returned when session strategy requires user id, but FSM was not able to get it from update.
+type NoUserIdError struct {
+	*tgbotapi.Update
+}
+
+func (e *NoUserIdError) Error() string {
+	return fmt.Sprintf("no user id in update: %+v", e.Update)
+}
+
+// AmbiguousSessionError Returned by GoTo and GoToTopic for group chats when session strategy requires user id, since
+// the group has a session per member. Use GoToSession instead.
+type AmbiguousSessionError struct {
+	ChatId int64
+}
+
+func (e *AmbiguousSessionError) Error() string {
+	return fmt.Sprintf("chat %d session is ambiguous for per user session strategy, use GoToSession", e.ChatId)
+}
+
+// SessionKey identifies FSM session, which has its own state, data and meta. Fields not used by the session strategy
+// are 0.
+type SessionKey struct {
+	ChatId int64
+	UserId int64
+	// Forum topic id (message_thread_id).
+	ThreadId int
+}
+
+// String returns key representation, which can be used as a storage key. Keys of PerChatSession strategy are
+// represented as bare chat ids, so storage keys don't change when per chat sessions were stored by chat id.
+func (k SessionKey) String() string {
+	if k.UserId == 0 && k.ThreadId == 0 {
+		return strconv.FormatInt(k.ChatId, 10)
+	}
+	return fmt.Sprintf("%d:%d:%d", k.ChatId, k.UserId, k.ThreadId)
+}
+
+// SessionStrategy defines how updates are grouped into sessions.
+type SessionStrategy int
+
+const (
+	// PerChatSession is the default strategy: all chat members share the same session.
+	PerChatSession SessionStrategy = iota
+	// PerUserSession makes a session per user, which is shared across all chats with the user.
+	PerUserSession
+	// PerChatUserSession makes a separate session for every user in every chat.
+	PerChatUserSession
+	// PerTopicSession makes a separate session for every forum topic. It requires HandleRawUpdate, since tgbotapi
+	// doesn't support message_thread_id.
+	PerTopicSession
+)
+
+func WithSessionStrategy[T any](strategy SessionStrategy) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.sessionStrategy = strategy
+	}
+}
+
+// GoToSession works like GoTo, but for the given session. Messages are sent to the session chat or, if it's 0, to the
+// private chat with the session user. If session has a forum topic, messages are sent there.
+func (b *BotFsm[T]) GoToSession(ctx context.Context, sessionKey SessionKey, transition Transition, data T) error {
+	chatId := sessionKey.ChatId
+	if chatId == 0 {
+		chatId = sessionKey.UserId
+	}
+	return b.goTo(ctx, sessionKey, chatId, sessionKey.ThreadId, transition, data)
+}
+
+// HandleRawUpdate works like HandleUpdate, but accepts update JSON. Use it when update fields unsupported by tgbotapi
+// (e.g. message_thread_id or web_app_data) are needed.
+func (b *BotFsm[T]) HandleRawUpdate(ctx context.Context, data []byte) error {
+	var update tgbotapi.Update
+	err := json.Unmarshal(data, &update)
+	if err != nil {
+		return err
+	}
+	var extras rawUpdateExtras
+	err = json.Unmarshal(data, &extras)
+	if err != nil {
+		return err
+	}
+	return b.handleUpdateWithExtras(ctx, &update, extras.updateExtras())
+}
+
+// updateExtras contains update fields unsupported by tgbotapi.
+type updateExtras struct {
+	threadId   int
+	webAppData *WebAppData
+}
+
+type rawMessageExtras struct {
+	MessageThreadId int         `json:"message_thread_id"`
+	IsTopicMessage  bool        `json:"is_topic_message"`
+	WebAppData      *WebAppData `json:"web_app_data"`
+}
+
+// getThreadId returns the forum topic id. Messages outside of forums have message_thread_id too (it's the id of the
+// replied thread), so it's taken into account only for topic messages.
+func (e *rawMessageExtras) getThreadId() int {
+	if !e.IsTopicMessage {
+		return 0
+	}
+	return e.MessageThreadId
+}
+
+type rawUpdateExtras struct {
+	Message       *rawMessageExtras `json:"message"`
+	EditedMessage *rawMessageExtras `json:"edited_message"`
+	CallbackQuery *struct {
+		Message *rawMessageExtras `json:"message"`
+	} `json:"callback_query"`
+}
+
+func (e rawUpdateExtras) updateExtras() updateExtras {
+	var extras updateExtras
+	switch {
+	case e.Message != nil:
+		extras.threadId = e.Message.getThreadId()
+		extras.webAppData = e.Message.WebAppData
+	case e.EditedMessage != nil:
+		extras.threadId = e.EditedMessage.getThreadId()
+	case e.CallbackQuery != nil && e.CallbackQuery.Message != nil:
+		extras.threadId = e.CallbackQuery.Message.getThreadId()
+	}
+	return extras
+}
+
+// getSessionKey builds session key for update according to session strategy.
+func (b *BotFsm[T]) getSessionKey(update *tgbotapi.Update, chatId int64, extras updateExtras) (SessionKey, error) {
+	switch b.sessionStrategy {
+	case PerUserSession, PerChatUserSession:
+		userId := getUserId(update)
+		if userId == 0 {
+			return SessionKey{}, &NoUserIdError{update}
+		}
+		if b.sessionStrategy == PerUserSession {
+			return SessionKey{UserId: userId}, nil
+		}
+		return SessionKey{ChatId: chatId, UserId: userId}, nil
+	case PerTopicSession:
+		return SessionKey{ChatId: chatId, ThreadId: extras.threadId}, nil
+	default:
+		return SessionKey{ChatId: chatId}, nil
+	}
+}
+
+// getChatSessionKey returns the key of the chat session for GoTo. Sessions of private chats are derived from the chat
+// id for every strategy, since the chat id is the user id there. Group sessions can't be derived for strategies
+// requiring user id.
+func (b *BotFsm[T]) getChatSessionKey(chatId int64, threadId int) (SessionKey, error) {
+	switch {
+	case b.sessionStrategy == PerTopicSession:
+		return SessionKey{ChatId: chatId, ThreadId: threadId}, nil
+	case chatId > 0:
+		return b.getPrivateSessionKey(chatId), nil
+	case b.sessionStrategy == PerChatSession:
+		return SessionKey{ChatId: chatId}, nil
+	default:
+		return SessionKey{}, &AmbiguousSessionError{chatId}
+	}
+}
+
+// getPrivateSessionKey returns the key of the session of the user private chat with the bot.
+func (b *BotFsm[T]) getPrivateSessionKey(userId int64) SessionKey {
+	switch b.sessionStrategy {
+	case PerUserSession:
+		return SessionKey{UserId: userId}
+	case PerChatUserSession:
+		return SessionKey{ChatId: userId, UserId: userId}
+	default:
+		return SessionKey{ChatId: userId}
+	}
+}
+
+// getUserId returns id of the user who sent the update. It's 0 if update has no sender, e.g. channel posts.
+func getUserId(update *tgbotapi.Update) int64 {
+	var user *tgbotapi.User
+	switch {
+	case update.Message != nil:
+		user = update.Message.From
+	case update.EditedMessage != nil:
+		user = update.EditedMessage.From
+	case update.CallbackQuery != nil:
+		user = update.CallbackQuery.From
+	case update.InlineQuery != nil:
+		user = update.InlineQuery.From
+	case update.ChosenInlineResult != nil:
+		user = update.ChosenInlineResult.From
+	case update.ShippingQuery != nil:
+		user = update.ShippingQuery.From
+	case update.PreCheckoutQuery != nil:
+		user = update.PreCheckoutQuery.From
+	case update.PollAnswer != nil:
+		user = &update.PollAnswer.User
+	case update.MyChatMember != nil:
+		user = &update.MyChatMember.From
+	case update.ChatMember != nil:
+		user = &update.ChatMember.From
+	case update.ChatJoinRequest != nil:
+		user = &update.ChatJoinRequest.From
+	}
+	if user == nil {
+		return 0
+	}
+	return user.ID
+}
@@ -0,0 +1,105 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRawUpdateThread(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		threadId string
+	}{
+		{"topic message", `"message_thread_id":7,"is_topic_message":true`, "7"},
+		{"reply outside of forum", `"message_thread_id":7`, ""},
+		{"general topic", ``, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, bot := newFakeTelegram(t)
+			builder := New[int]()
+			builder.State(UndefinedState).Text("start")
+			botFsm := builder.Build(bot)
+
+			message := `{"message_id":1,"text":"hi","chat":{"id":-1,"type":"supergroup"},"from":{"id":1}`
+			if test.message != "" {
+				message += "," + test.message
+			}
+			update := `{"update_id":1,"message":` + message + `}}`
+			if err := botFsm.HandleRawUpdate(context.Background(), []byte(update)); err != nil {
+				t.Fatalf("update error: %s", err)
+			}
+			requests := fake.sent("sendMessage")
+			if len(requests) != 1 || requests[0].Params.Get("message_thread_id") != test.threadId {
+				t.Fatalf("expected message sent to thread %q, got %v", test.threadId, requests)
+			}
+		})
+	}
+}
+
+func TestSessionKeyString(t *testing.T) {
+	tests := []struct {
+		sessionKey SessionKey
+		expected   string
+	}{
+		{SessionKey{ChatId: 123}, "123"},
+		{SessionKey{ChatId: -123}, "-123"},
+		{SessionKey{UserId: 456}, "0:456:0"},
+		{SessionKey{ChatId: -123, UserId: 456}, "-123:456:0"},
+		{SessionKey{ChatId: -123, ThreadId: 7}, "-123:0:7"},
+	}
+	for _, test := range tests {
+		if actual := test.sessionKey.String(); actual != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, actual)
+		}
+	}
+}
+
+func TestGoToSessionKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		strategy   SessionStrategy
+		chatId     int64
+		sessionKey SessionKey
+		ambiguous  bool
+	}{
+		{"private per chat", PerChatSession, 1, SessionKey{ChatId: 1}, false},
+		{"private per user", PerUserSession, 1, SessionKey{UserId: 1}, false},
+		{"private per chat user", PerChatUserSession, 1, SessionKey{ChatId: 1, UserId: 1}, false},
+		{"group per chat", PerChatSession, -1, SessionKey{ChatId: -1}, false},
+		{"group per topic", PerTopicSession, -1, SessionKey{ChatId: -1}, false},
+		{"group per user", PerUserSession, -1, SessionKey{}, true},
+		{"group per chat user", PerChatUserSession, -1, SessionKey{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, bot := newFakeTelegram(t)
+			builder := New[int]()
+			builder.State(UndefinedState).Text("start")
+			builder.State("next").Text("next")
+			botFsm := builder.Build(bot, WithSessionStrategy[int](test.strategy))
+			ctx := context.Background()
+
+			err := botFsm.GoTo(ctx, test.chatId, StateTransition("next"), 0)
+			var ambiguousErr *AmbiguousSessionError
+			if test.ambiguous {
+				if !errors.As(err, &ambiguousErr) {
+					t.Fatalf("expected AmbiguousSessionError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("goto error: %s", err)
+			}
+			state, _, _, err := botFsm.loadState(ctx, test.sessionKey)
+			if err != nil {
+				t.Fatalf("load error: %s", err)
+			}
+			if state != "next" {
+				t.Fatalf("expected session %v switched, got state %s", test.sessionKey, state)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+)
+
+// EmptyCallStackError Returned on attempt to return from a sub-flow when there is no sub-flow call in the stack.
+type EmptyCallStackError struct {
+	State
+}
+
+func (e *EmptyCallStackError) Error() string {
+	return fmt.Sprintf("cannot return from state %s: call stack is empty", e.State)
+}
+
+// MetaPersistenceRequiredError Returned on sub-flow call or back transition when PersistenceHandler doesn't implement
+// MetaPersistenceHandler, since call stack and history would be lost on restart.
+type MetaPersistenceRequiredError struct {
+	State
+}
+
+func (e *MetaPersistenceRequiredError) Error() string {
+	return fmt.Sprintf("state %s: sub-flows and history require MetaPersistenceHandler", e.State)
+}
+
+// StackFrame describes a single sub-flow call.
+type StackFrame struct {
+	// The state bot returns to when the sub-flow is finished.
+	ReturnState State
+}
+
+// ReturnHandler may be implemented by StateHandler to receive sub-flow results.
+type ReturnHandler[T any] interface {
+	// ReturnFn is called when a sub-flow returns to this state. It works like TransitionFn, but receives sub-flow
+	// result instead of update. Empty transition State means the bot stays in this state.
+	ReturnFn(ctx context.Context, result string, data T) (Transition, T)
+}
+
+// CallTransition switches bot to the initial state of a sub-flow. Once the sub-flow is finished with
+// ReturnTransition, the bot returns to returnState. If returnState is empty, the bot returns to the calling state.
+func CallTransition(state State, returnState State) Transition {
+	return Transition{State: state, kind: callTransitionKind, returnState: returnState}
+}
+
+// ReturnTransition finishes the current sub-flow and passes result to the state the sub-flow was called from.
+func ReturnTransition(result string) Transition {
+	return Transition{kind: returnTransitionKind, result: result}
+}
+
+type transitionKind int
+
+const (
+	regularTransitionKind transitionKind = iota
+	callTransitionKind
+	returnTransitionKind
+	backTransitionKind
+)
+
+// resolveSubFlow converts sub-flow call and return transitions into regular ones, updating the call stack. Transitions
+// made by state handlers, including ReturnFn, are checked from the state which made them.
+func (b *BotFsm[T]) resolveSubFlow(
+	ctx context.Context,
+	state State,
+	transition Transition,
+	data T,
+	stack []StackFrame,
+) (Transition, T, []StackFrame, error) {
+	if transition.kind == regularTransitionKind || transition.kind == callTransitionKind {
+		transition.checkFrom = state
+	}
+	switch transition.kind {
+	case callTransitionKind:
+		returnState := transition.returnState
+		if returnState == "" {
+			returnState = state
+		}
+		transition.kind = regularTransitionKind
+		return transition, data, append(stack, StackFrame{ReturnState: returnState}), nil
+	case returnTransitionKind:
+		if len(stack) == 0 {
+			return transition, data, stack, &EmptyCallStackError{state}
+		}
+		frame := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		returnStateHandler, ok := b.configs[frame.ReturnState]
+		if !ok {
+			return transition, data, stack, &NextStateConfigNotFoundError{frame.ReturnState}
+		}
+		returnHandler, ok := handlerAs[ReturnHandler[T]](returnStateHandler)
+		if !ok {
+			transition.State = frame.ReturnState
+			transition.kind = regularTransitionKind
+			return transition, data, stack, nil
+		}
+		returnTransition, newData := returnHandler.ReturnFn(ctx, transition.result, data)
+		if returnTransition.State == "" && returnTransition.kind != returnTransitionKind {
+			returnTransition.State = frame.ReturnState
+		}
+		// Return state handler may call another sub-flow or return further.
+		return b.resolveSubFlow(ctx, frame.ReturnState, returnTransition, newData, stack)
+	default:
+		return transition, data, stack, nil
+	}
+}
@@ -0,0 +1,279 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// statePersistenceHandler stores state and data only.
+type statePersistenceHandler struct {
+	mx     sync.Mutex
+	states map[SessionKey]State
+	data   map[SessionKey]int
+}
+
+func newStatePersistenceHandler() *statePersistenceHandler {
+	return &statePersistenceHandler{states: make(map[SessionKey]State), data: make(map[SessionKey]int)}
+}
+
+func (h *statePersistenceHandler) LoadStateFn(ctx context.Context, sessionKey SessionKey) (State, int, error) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	return h.states[sessionKey], h.data[sessionKey], nil
+}
+
+func (h *statePersistenceHandler) SaveStateFn(ctx context.Context, sessionKey SessionKey, state State, data int) error {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.states[sessionKey], h.data[sessionKey] = state, data
+	return nil
+}
+
+// metaPersistenceHandler stores state, data and meta, and counts save calls.
+type metaPersistenceHandler struct {
+	*statePersistenceHandler
+	metas map[SessionKey]Meta[int]
+	saves int
+}
+
+func newMetaPersistenceHandler() *metaPersistenceHandler {
+	return &metaPersistenceHandler{
+		statePersistenceHandler: newStatePersistenceHandler(),
+		metas:                   make(map[SessionKey]Meta[int]),
+	}
+}
+
+func (h *metaPersistenceHandler) LoadMetaFn(ctx context.Context, sessionKey SessionKey) (Meta[int], error) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	return h.metas[sessionKey], nil
+}
+
+func (h *metaPersistenceHandler) SaveStateWithMetaFn(
+	ctx context.Context,
+	sessionKey SessionKey,
+	state State,
+	data int,
+	meta Meta[int],
+) error {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.states[sessionKey], h.data[sessionKey], h.metas[sessionKey] = state, data, meta
+	h.saves++
+	return nil
+}
+
+func (h *metaPersistenceHandler) SaveStateFn(ctx context.Context, sessionKey SessionKey, state State, data int) error {
+	panic("SaveStateWithMetaFn must be used")
+}
+
+func subFlowBuilder() *Builder[int] {
+	builder := New[int]()
+	builder.State(UndefinedState).Text("menu").
+		OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+			return CallTransition("confirm", ""), data
+		})
+	builder.State("confirm").Text("sure?").
+		OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+			return ReturnTransition(update.Message.Text), data
+		})
+	return builder
+}
+
+func TestSubFlowStackSurvivesRestart(t *testing.T) {
+	fake, bot := newFakeTelegram(t)
+	handler := newMetaPersistenceHandler()
+	ctx := context.Background()
+
+	err := subFlowBuilder().Build(bot, WithPersistenceHandler[int](handler)).HandleUpdate(ctx, textUpdate(1, "go"))
+	if err != nil {
+		t.Fatalf("call error: %s", err)
+	}
+	if handler.saves != 1 {
+		t.Fatalf("expected state and meta saved in 1 call, got %d", handler.saves)
+	}
+	// The new FSM instance emulates restart.
+	err = subFlowBuilder().Build(bot, WithPersistenceHandler[int](handler)).HandleUpdate(ctx, textUpdate(1, "yes"))
+	if err != nil {
+		t.Fatalf("return error: %s", err)
+	}
+	texts := fake.sentTexts()
+	if len(texts) != 2 || texts[1] != "menu" {
+		t.Fatalf("unexpected messages: %v", texts)
+	}
+}
+
+func TestSubFlowRequiresMetaPersistence(t *testing.T) {
+	_, bot := newFakeTelegram(t)
+	botFsm := subFlowBuilder().Build(bot, WithPersistenceHandler[int](newStatePersistenceHandler()))
+
+	err := botFsm.HandleUpdate(context.Background(), textUpdate(1, "go"))
+	var metaErr *MetaPersistenceRequiredError
+	if !errors.As(err, &metaErr) {
+		t.Fatalf("expected MetaPersistenceRequiredError, got %v", err)
+	}
+}
+
+type returnStateHandler struct {
+	funcStateHandler[int]
+}
+
+func (h *returnStateHandler) ReturnFn(ctx context.Context, result string, data int) (Transition, int) {
+	return Transition{}, data
+}
+
+func TestValidateMetaPersistence(t *testing.T) {
+	configs := map[State]StateHandler[int]{
+		UndefinedState: &funcStateHandler[int]{},
+		"menu":         &returnStateHandler{},
+	}
+	tests := []struct {
+		name     string
+		optFns   []BotFsmOptsFn[int]
+		problems int
+	}{
+		{"in-memory persistence", nil, 0},
+		{"meta persistence", []BotFsmOptsFn[int]{WithPersistenceHandler[int](newMetaPersistenceHandler())}, 0},
+		{"state persistence", []BotFsmOptsFn[int]{WithPersistenceHandler[int](newStatePersistenceHandler())}, 1},
+		{"state persistence with history", []BotFsmOptsFn[int]{
+			WithPersistenceHandler[int](newStatePersistenceHandler()),
+			WithHistory[int](5),
+		}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := newBotFsm(nil, configs, test.optFns...).Validate()
+			var problems int
+			for _, problem := range report.Errors() {
+				if problem.Kind == MissingMetaPersistenceProblem {
+					problems++
+				}
+			}
+			if problems != test.problems {
+				t.Fatalf("expected %d problems, got %v", test.problems, report.Problems)
+			}
+		})
+	}
+}
+
+// returnFuncStateHandler is a builder state handler implementing ReturnHandler.
+type returnFuncStateHandler struct {
+	*funcStateHandler[int]
+	returnFn func(result string) Transition
+}
+
+func (h returnFuncStateHandler) ReturnFn(ctx context.Context, result string, data int) (Transition, int) {
+	return h.returnFn(result), data
+}
+
+func TestSubFlowTransitionRules(t *testing.T) {
+	allow := func(ctx context.Context, data int) bool { return true }
+	deny := func(ctx context.Context, data int) bool { return false }
+	tests := []struct {
+		name      string
+		input     string
+		guard     GuardFn[int]
+		state     State
+		errTarget any
+	}{
+		{"return handler transition allowed", "ok", allow, "done", nil},
+		{"return handler transition rejected by guard", "ok", deny, "confirm", new(*TransitionGuardError)},
+		{"return handler transition undeclared", "other", allow, "confirm", new(*IllegalTransitionError)},
+		{"plain return not checked", "stay", deny, "hub", nil},
+		{"back not checked", "back", deny, UndefinedState, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, bot := newFakeTelegram(t)
+			builder := New[int]()
+			builder.State(UndefinedState).Text("start").
+				OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+					return CallTransition("confirm", "hub"), data
+				})
+			builder.State("confirm").Text("sure?").
+				OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+					if update.Message.Text == "back" {
+						return BackTransition(), data
+					}
+					return ReturnTransition(update.Message.Text), data
+				})
+			builder.State("done").Text("done")
+			builder.State("other").Text("other")
+			configs, commands := builder.Configs()
+			configs["hub"] = returnFuncStateHandler{
+				funcStateHandler: &funcStateHandler[int]{},
+				returnFn: func(result string) Transition {
+					switch result {
+					case "ok":
+						return StateTransition("done")
+					case "other":
+						return StateTransition("other")
+					default:
+						return Transition{}
+					}
+				},
+			}
+			botFsm := NewBotFsm(bot, configs, WithCommands[int](commands), WithHistory[int](5),
+				WithTransitionRules(
+					TransitionRule[int]{From: UndefinedState, To: "confirm"},
+					TransitionRule[int]{From: "hub", To: "done", Guard: test.guard},
+				))
+			ctx := context.Background()
+
+			if err := botFsm.HandleUpdate(ctx, textUpdate(1, "go")); err != nil {
+				t.Fatalf("call error: %s", err)
+			}
+			err := botFsm.HandleUpdate(ctx, textUpdate(1, test.input))
+			if test.errTarget == nil && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.errTarget != nil && !errors.As(err, test.errTarget) {
+				t.Fatalf("expected %T, got %v", test.errTarget, err)
+			}
+			state, _, _, err := botFsm.loadState(ctx, SessionKey{ChatId: 1})
+			if err != nil {
+				t.Fatalf("load error: %s", err)
+			}
+			if state != test.state {
+				t.Fatalf("expected state %s, got %s", test.state, state)
+			}
+		})
+	}
+}
+
+func TestGoToResetsSubFlowStack(t *testing.T) {
+	tests := []struct {
+		name       string
+		transition Transition
+		stack      int
+	}{
+		{"same state", StateTransition("confirm"), 1},
+		{"another state", StateTransition(UndefinedState), 0},
+		{"call", CallTransition("confirm", ""), 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, bot := newFakeTelegram(t)
+			botFsm := subFlowBuilder().Build(bot, WithPersistenceHandler[int](newMetaPersistenceHandler()))
+			ctx := context.Background()
+			if err := botFsm.HandleUpdate(ctx, textUpdate(1, "go")); err != nil {
+				t.Fatalf("call error: %s", err)
+			}
+
+			if err := botFsm.GoTo(ctx, 1, test.transition, 0); err != nil {
+				t.Fatalf("goto error: %s", err)
+			}
+			_, _, meta, err := botFsm.loadState(ctx, SessionKey{ChatId: 1})
+			if err != nil {
+				t.Fatalf("load error: %s", err)
+			}
+			if len(meta.Stack) != test.stack {
+				t.Fatalf("expected %d stack frames, got %v", test.stack, meta.Stack)
+			}
+		})
+	}
+}
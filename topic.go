@@ -0,0 +1,105 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// GoToTopic works like GoTo, but sends messages to the given forum topic. It's the topic session which is switched
+// for PerTopicSession strategy, and the chat session otherwise.
+func (b *BotFsm[T]) GoToTopic(ctx context.Context, chatId int64, threadId int, transition Transition, data T) error {
+	sessionKey, err := b.getChatSessionKey(chatId, threadId)
+	if err != nil {
+		return err
+	}
+	return b.goTo(ctx, sessionKey, chatId, threadId, transition, data)
+}
+
+// sendToThread sends message or invoice to the forum topic. tgbotapi doesn't support message_thread_id, so request
+// params are built here in the same way tgbotapi does. Other chattables are sent as is.
+func (b *BotFsm[T]) sendToThread(chattable tgbotapi.Chattable, threadId int) (tgbotapi.Message, error) {
+	var method string
+	var params tgbotapi.Params
+	var err error
+	switch config := chattable.(type) {
+	case tgbotapi.MessageConfig:
+		method = "sendMessage"
+		params, err = getMessageParams(config)
+	case tgbotapi.InvoiceConfig:
+		method = "sendInvoice"
+		params, err = getInvoiceParams(config)
+	default:
+		return b.bot.Send(chattable)
+	}
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+	params.AddNonZero("message_thread_id", threadId)
+
+	resp, err := b.bot.MakeRequest(method, params)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+	var message tgbotapi.Message
+	err = json.Unmarshal(resp.Result, &message)
+	return message, err
+}
+
+func getBaseChatParams(chat tgbotapi.BaseChat) (tgbotapi.Params, error) {
+	params := make(tgbotapi.Params)
+	params.AddFirstValid("chat_id", chat.ChatID, chat.ChannelUsername)
+	params.AddNonZero("reply_to_message_id", chat.ReplyToMessageID)
+	params.AddBool("disable_notification", chat.DisableNotification)
+	params.AddBool("allow_sending_without_reply", chat.AllowSendingWithoutReply)
+	err := params.AddInterface("reply_markup", chat.ReplyMarkup)
+	return params, err
+}
+
+func getMessageParams(msg tgbotapi.MessageConfig) (tgbotapi.Params, error) {
+	params, err := getBaseChatParams(msg.BaseChat)
+	if err != nil {
+		return params, err
+	}
+	params.AddNonEmpty("text", msg.Text)
+	params.AddBool("disable_web_page_preview", msg.DisableWebPagePreview)
+	params.AddNonEmpty("parse_mode", msg.ParseMode)
+	err = params.AddInterface("entities", msg.Entities)
+	return params, err
+}
+
+func getInvoiceParams(invoice tgbotapi.InvoiceConfig) (tgbotapi.Params, error) {
+	params, err := getBaseChatParams(invoice.BaseChat)
+	if err != nil {
+		return params, err
+	}
+	params["title"] = invoice.Title
+	params["description"] = invoice.Description
+	params["payload"] = invoice.Payload
+	params["provider_token"] = invoice.ProviderToken
+	params["currency"] = invoice.Currency
+	err = params.AddInterface("prices", invoice.Prices)
+	if err != nil {
+		return params, err
+	}
+	err = params.AddInterface("suggested_tip_amounts", invoice.SuggestedTipAmounts)
+	if err != nil {
+		return params, err
+	}
+	params.AddNonZero("max_tip_amount", invoice.MaxTipAmount)
+	params.AddNonEmpty("start_parameter", invoice.StartParameter)
+	params.AddNonEmpty("provider_data", invoice.ProviderData)
+	params.AddNonEmpty("photo_url", invoice.PhotoURL)
+	params.AddNonZero("photo_size", invoice.PhotoSize)
+	params.AddNonZero("photo_width", invoice.PhotoWidth)
+	params.AddNonZero("photo_height", invoice.PhotoHeight)
+	params.AddBool("need_name", invoice.NeedName)
+	params.AddBool("need_phone_number", invoice.NeedPhoneNumber)
+	params.AddBool("need_email", invoice.NeedEmail)
+	params.AddBool("need_shipping_address", invoice.NeedShippingAddress)
+	params.AddBool("is_flexible", invoice.IsFlexible)
+	params.AddBool("send_phone_number_to_provider", invoice.SendPhoneNumberToProvider)
+	params.AddBool("send_email_to_provider", invoice.SendEmailToProvider)
+	return params, nil
+}
@@ -0,0 +1,35 @@
+package fsm
+
+import (
+	"context"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestGoToTopicWithInvoice(t *testing.T) {
+	fake, bot := newFakeTelegram(t)
+	builder := New[int]()
+	builder.State(UndefinedState).Text("start")
+	builder.State("pay").Message(func(ctx context.Context, data int) MessageConfig {
+		messageConfig := InvoiceMessageConfig("Pro", "Pro plan", "pro", "USD",
+			tgbotapi.LabeledPrice{Label: "Pro", Amount: 500})
+		messageConfig.Text = "pay, please"
+		return messageConfig
+	})
+	botFsm := builder.Build(bot, WithPaymentProviderToken[int]("provider"))
+
+	if err := botFsm.GoToTopic(context.Background(), -1, 7, StateTransition("pay"), 0); err != nil {
+		t.Fatalf("goto error: %s", err)
+	}
+	for _, method := range []string{"sendMessage", "sendInvoice"} {
+		requests := fake.sent(method)
+		if len(requests) != 1 || requests[0].Params.Get("message_thread_id") != "7" {
+			t.Fatalf("expected %s sent to topic, got %v", method, requests)
+		}
+	}
+	invoice := fake.sent("sendInvoice")[0].Params
+	if invoice.Get("provider_token") != "provider" || invoice.Get("prices") != `[{"label":"Pro","amount":500}]` {
+		t.Fatalf("unexpected invoice params: %v", invoice)
+	}
+}
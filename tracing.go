@@ -0,0 +1,142 @@
+package fsm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Tracer starts spans for update processing steps. Its shape follows OpenTelemetry API, so an adapter for
+// OpenTelemetry tracer is a few lines of code.
+type Tracer interface {
+	// Start starts a span, which is a child of the span stored in ctx, if any. Returned context contains the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation.
+type Span interface {
+	SetAttribute(key string, value any)
+	RecordError(err error)
+	End()
+}
+
+// WithTracer sets Tracer. HandleUpdate and GoTo produce a root span with child spans for LoadStateFn, TransitionFn,
+// MessageFn, removeKeyboard, SaveStateFn and each Send. Context passed to handlers contains the current span.
+func WithTracer[T any](tracer Tracer) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		opts.tracer = tracer
+	}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, any) {}
+func (noopSpan) RecordError(error)        {}
+func (noopSpan) End()                     {}
+
+// endSpan records error, if it's not nil, and ends span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// RecordedSpan describes a span recorded by RecordingTracer.
+type RecordedSpan struct {
+	// Span id, unique within the tracer.
+	Id int
+	// Parent span id. It's 0 for root spans.
+	ParentId   int
+	Name       string
+	Attributes map[string]any
+	Errors     []error
+	StartTime  time.Time
+	// It's zero, if span is not ended yet.
+	EndTime time.Time
+}
+
+// RecordingTracer is a Tracer which keeps spans in memory. It's useful in tests. Use NewRecordingTracer to create it.
+type RecordingTracer struct {
+	mx     sync.Mutex
+	lastId int
+	spans  []*RecordedSpan
+}
+
+func NewRecordingTracer() *RecordingTracer {
+	return &RecordingTracer{}
+}
+
+type recordingSpanContextKey struct{}
+
+type recordingSpan struct {
+	tracer *RecordingTracer
+	*RecordedSpan
+}
+
+func (t *RecordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.lastId++
+	recordedSpan := &RecordedSpan{
+		Id:         t.lastId,
+		Name:       name,
+		Attributes: make(map[string]any),
+		StartTime:  time.Now(),
+	}
+	if parent, ok := ctx.Value(recordingSpanContextKey{}).(*recordingSpan); ok && parent.tracer == t {
+		recordedSpan.ParentId = parent.Id
+	}
+	t.spans = append(t.spans, recordedSpan)
+	span := &recordingSpan{tracer: t, RecordedSpan: recordedSpan}
+	return context.WithValue(ctx, recordingSpanContextKey{}, span), span
+}
+
+// Spans returns copies of all recorded spans in the order they were started.
+func (t *RecordingTracer) Spans() []RecordedSpan {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	spans := make([]RecordedSpan, len(t.spans))
+	for i, span := range t.spans {
+		spans[i] = *span
+		spans[i].Attributes = make(map[string]any, len(span.Attributes))
+		for key, value := range span.Attributes {
+			spans[i].Attributes[key] = value
+		}
+		spans[i].Errors = append([]error(nil), span.Errors...)
+	}
+	return spans
+}
+
+// Reset removes all recorded spans.
+func (t *RecordingTracer) Reset() {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.spans = nil
+}
+
+func (s *recordingSpan) SetAttribute(key string, value any) {
+	s.tracer.mx.Lock()
+	defer s.tracer.mx.Unlock()
+	s.Attributes[key] = value
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.tracer.mx.Lock()
+	defer s.tracer.mx.Unlock()
+	s.Errors = append(s.Errors, err)
+}
+
+func (s *recordingSpan) End() {
+	s.tracer.mx.Lock()
+	defer s.tracer.mx.Unlock()
+	if s.EndTime.IsZero() {
+		s.EndTime = time.Now()
+	}
+}
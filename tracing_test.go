@@ -0,0 +1,32 @@
+package fsm
+
+import (
+	"context"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestRemoveKeyboardSpans(t *testing.T) {
+	fake, bot := newFakeTelegram(t)
+	tracer := NewRecordingTracer()
+	builder := New[int]()
+	builder.State(UndefinedState).Text("start").
+		OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+			return StateTransition("next"), data
+		})
+	builder.State("next").Text("next").RemoveKeyboardBefore()
+	botFsm := builder.Build(bot, WithTracer[int](tracer))
+
+	if err := botFsm.HandleUpdate(context.Background(), textUpdate(1, "hi")); err != nil {
+		t.Fatalf("update error: %s", err)
+	}
+	if deleted := fake.sent("deleteMessage"); len(deleted) != 1 {
+		t.Fatalf("expected temporary message deleted, got %v", deleted)
+	}
+	for _, span := range tracer.Spans() {
+		if len(span.Errors) != 0 {
+			t.Fatalf("unexpected span %s errors: %v", span.Name, span.Errors)
+		}
+	}
+}
@@ -17,6 +17,9 @@ type MessageConfig struct {
 	ExtraTexts []string
 	// If true, it will send and remove RemoveKeyboard message prior to main message sending.
 	RemoveKeyboard bool
+	// Invoice is sent after the messages. Text may be empty, if invoice is set. ChatId field is ignored. See
+	// InvoiceMessageConfig.
+	Invoice *tgbotapi.InvoiceConfig
 }
 
 func TextMessageConfig(text string) MessageConfig {
@@ -26,7 +29,7 @@ func TextMessageConfig(text string) MessageConfig {
 }
 
 func (m MessageConfig) Empty() bool {
-	return m.Text == ""
+	return m.Text == "" && m.Invoice == nil
 }
 
 // Transition describes state switching rule.
@@ -36,6 +39,13 @@ type Transition struct {
 	// Defines transition bot message. If MessageConfig Text field is empty, the next state MessageFn
 	// will be called to get MessageConfig.
 	MessageConfig
+	// Sub-flow related fields. See CallTransition and ReturnTransition.
+	kind        transitionKind
+	returnState State
+	result      string
+	// The state resolved transition is checked from against transition rules. It's empty for back and plain return
+	// transitions, since they lead to already visited states.
+	checkFrom State
 }
 
 // StateTransition simplifies Transition object creation for state switches.
@@ -78,7 +88,34 @@ type RemoveKeyboardBeforeMarker interface {
 	RemoveKeyboardBefore() bool
 }
 
+// PersistenceHandler stores state and data per session. See SessionStrategy.
 type PersistenceHandler[T any] interface {
-	LoadStateFn(ctx context.Context, chatId int64) (state State, data T, err error)
-	SaveStateFn(ctx context.Context, chatId int64, state State, data T) error
+	LoadStateFn(ctx context.Context, sessionKey SessionKey) (state State, data T, err error)
+	SaveStateFn(ctx context.Context, sessionKey SessionKey, state State, data T) error
+}
+
+// Meta contains FSM service information which is persisted along with state and data.
+type Meta[T any] struct {
+	// Sub-flow call stack. The last frame is the most recent call.
+	Stack []StackFrame
+	// Previously visited states. The last entry is the most recent one. It's populated only when history is enabled
+	// with WithHistory option.
+	History []HistoryEntry[T]
+	// Inactive is true when the bot is blocked by the user or removed from the group. GoTo does nothing for inactive
+	// sessions. The session is marked active again on any update from its chat. See LifecycleHooks.
+	Inactive bool
+}
+
+func (m Meta[T]) empty() bool {
+	return len(m.Stack) == 0 && len(m.History) == 0 && !m.Inactive
+}
+
+// MetaPersistenceHandler may be implemented by PersistenceHandler to store Meta in the same storage. It's required
+// for sub-flows and history (see Validate). If it is not implemented, Meta is kept in memory, so sub-flow calls and
+// back transitions fail with MetaPersistenceRequiredError.
+type MetaPersistenceHandler[T any] interface {
+	LoadMetaFn(ctx context.Context, sessionKey SessionKey) (Meta[T], error)
+	// SaveStateWithMetaFn is called instead of SaveStateFn. It saves state, data and meta at once, so they can't get
+	// out of sync.
+	SaveStateWithMetaFn(ctx context.Context, sessionKey SessionKey, state State, data T, meta Meta[T]) error
 }
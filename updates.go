@@ -0,0 +1,132 @@
+package fsm
+
+import (
+	"context"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// UpdateType is the name of the update field which is set.
+type UpdateType string
+
+const (
+	MessageUpdate            UpdateType = "message"
+	EditedMessageUpdate      UpdateType = "edited_message"
+	ChannelPostUpdate        UpdateType = "channel_post"
+	EditedChannelPostUpdate  UpdateType = "edited_channel_post"
+	InlineQueryUpdate        UpdateType = "inline_query"
+	ChosenInlineResultUpdate UpdateType = "chosen_inline_result"
+	CallbackQueryUpdate      UpdateType = "callback_query"
+	ShippingQueryUpdate      UpdateType = "shipping_query"
+	PreCheckoutQueryUpdate   UpdateType = "pre_checkout_query"
+	PollUpdate               UpdateType = "poll"
+	PollAnswerUpdate         UpdateType = "poll_answer"
+	MyChatMemberUpdate       UpdateType = "my_chat_member"
+	ChatMemberUpdate         UpdateType = "chat_member"
+	ChatJoinRequestUpdate    UpdateType = "chat_join_request"
+	UnknownUpdate            UpdateType = "unknown"
+)
+
+// UpdateTypeHandler may be implemented by StateHandler to handle updates of some types with dedicated
+// TransitionProviders. Messages and callback queries are passed to the state TransitionFn, unless another provider is
+// returned for them. Updates of other types are ignored by the state, unless a provider is returned for them: nothing
+// is saved or sent.
+type UpdateTypeHandler[T any] interface {
+	UpdateTypeHandlers() map[UpdateType]TransitionProvider[T]
+}
+
+// GlobalHandlerFn handles updates which don't belong to any chat, e.g. inline queries or poll answers.
+type GlobalHandlerFn func(ctx context.Context, update *tgbotapi.Update) error
+
+// WithGlobalHandler sets the handler for updates of the given type which don't belong to any chat. Without handler,
+// HandleUpdate returns NoChatIdError for such updates.
+func WithGlobalHandler[T any](updateType UpdateType, handler GlobalHandlerFn) BotFsmOptsFn[T] {
+	return func(opts *botFsmOpts[T]) {
+		if opts.globalHandlers == nil {
+			opts.globalHandlers = make(map[UpdateType]GlobalHandlerFn)
+		}
+		opts.globalHandlers[updateType] = handler
+	}
+}
+
+// handleChatlessUpdate passes update without chat to the corresponding handler.
+func (b *BotFsm[T]) handleChatlessUpdate(ctx context.Context, logger fsmLogger, update *tgbotapi.Update) error {
+	switch {
+	case update.InlineQuery != nil && b.inlineQueryHandler != nil:
+		return b.answerInlineQuery(ctx, update)
+	case update.ChosenInlineResult != nil && b.chosenInlineResultHandler != nil:
+		return b.handleChosenInlineResult(ctx, logger, update)
+	case update.PreCheckoutQuery != nil && b.preCheckoutQueryHandler != nil:
+		return b.answerPreCheckoutQuery(ctx, update)
+	case update.ShippingQuery != nil && b.shippingQueryHandler != nil:
+		return b.answerShippingQuery(ctx, update)
+	}
+	if globalHandler, ok := b.globalHandlers[getUpdateType(update)]; ok {
+		return globalHandler(ctx, update)
+	}
+	return &NoChatIdError{update}
+}
+
+// getTransitionProvider returns the state TransitionProvider for the update. It returns false, if the update should be
+// ignored by the state. webAppData is nil, if the update is not a web app data message.
+func getTransitionProvider[T any](
+	stateHandler StateHandler[T],
+	update *tgbotapi.Update,
+	webAppData *WebAppData,
+) (TransitionProvider[T], bool) {
+	updateType := getUpdateType(update)
+	if webAppData != nil {
+		if webAppDataHandler, ok := handlerAs[WebAppDataHandler[T]](stateHandler); ok {
+			return &webAppDataProvider[T]{webAppDataHandler, *webAppData}, true
+		}
+	}
+	if update.Message != nil && update.Message.SuccessfulPayment != nil {
+		if paymentHandler, ok := handlerAs[SuccessfulPaymentHandler[T]](stateHandler); ok {
+			return &successfulPaymentProvider[T]{paymentHandler}, true
+		}
+	}
+	if updateTypeHandler, ok := handlerAs[UpdateTypeHandler[T]](stateHandler); ok {
+		if provider, ok := updateTypeHandler.UpdateTypeHandlers()[updateType]; ok { //nolint:govet // it's ok
+			return provider, true
+		}
+	}
+	if updateType == MessageUpdate || updateType == CallbackQueryUpdate {
+		return stateHandler, true
+	}
+	return nil, false
+}
+
+func getUpdateType(update *tgbotapi.Update) UpdateType {
+	switch {
+	case update.Message != nil:
+		return MessageUpdate
+	case update.EditedMessage != nil:
+		return EditedMessageUpdate
+	case update.ChannelPost != nil:
+		return ChannelPostUpdate
+	case update.EditedChannelPost != nil:
+		return EditedChannelPostUpdate
+	case update.InlineQuery != nil:
+		return InlineQueryUpdate
+	case update.ChosenInlineResult != nil:
+		return ChosenInlineResultUpdate
+	case update.CallbackQuery != nil:
+		return CallbackQueryUpdate
+	case update.ShippingQuery != nil:
+		return ShippingQueryUpdate
+	case update.PreCheckoutQuery != nil:
+		return PreCheckoutQueryUpdate
+	case update.Poll != nil:
+		return PollUpdate
+	case update.PollAnswer != nil:
+		return PollAnswerUpdate
+	case update.MyChatMember != nil:
+		return MyChatMemberUpdate
+	case update.ChatMember != nil:
+		return ChatMemberUpdate
+	case update.ChatJoinRequest != nil:
+		return ChatJoinRequestUpdate
+	default:
+		return UnknownUpdate
+	}
+}
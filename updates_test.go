@@ -0,0 +1,81 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestUpdateTypeRouting(t *testing.T) {
+	editedUpdate := &tgbotapi.Update{EditedMessage: textUpdate(1, "edited").Message}
+	tests := []struct {
+		name          string
+		editedHandler bool
+		update        *tgbotapi.Update
+		state         State
+		texts         int
+	}{
+		{"edited message ignored", false, editedUpdate, UndefinedState, 0},
+		{"edited message handled", true, editedUpdate, "edited", 1},
+		{"message passed to TransitionFn", true, textUpdate(1, "text"), "text", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, bot := newFakeTelegram(t)
+			builder := New[int]()
+			builder.State(UndefinedState).Text("start").
+				OnText(func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+					return StateTransition("text"), data
+				})
+			if test.editedHandler {
+				builder.State(UndefinedState).
+					OnUpdateType(EditedMessageUpdate, func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+						return StateTransition("edited"), data
+					})
+			}
+			builder.State("text").Text("text")
+			builder.State("edited").Text("edited")
+			botFsm := builder.Build(bot)
+			ctx := context.Background()
+
+			if err := botFsm.HandleUpdate(ctx, test.update); err != nil {
+				t.Fatalf("update error: %s", err)
+			}
+			state, _, _, err := botFsm.loadState(ctx, SessionKey{ChatId: 1})
+			if err != nil {
+				t.Fatalf("load error: %s", err)
+			}
+			if texts := fake.sentTexts(); state != test.state || len(texts) != test.texts {
+				t.Fatalf("expected state %s and %d messages, got %s and %v", test.state, test.texts, state, texts)
+			}
+		})
+	}
+}
+
+func TestPollAnswerGlobalHandler(t *testing.T) {
+	update := &tgbotapi.Update{PollAnswer: &tgbotapi.PollAnswer{PollID: "poll", User: tgbotapi.User{ID: 1}}}
+	builder := New[int]()
+	builder.State(UndefinedState).Text("start")
+	ctx := context.Background()
+
+	err := builder.Build(nil).HandleUpdate(ctx, update)
+	var noChatIdErr *NoChatIdError
+	if !errors.As(err, &noChatIdErr) {
+		t.Fatalf("expected NoChatIdError, got %v", err)
+	}
+
+	var handled *tgbotapi.Update
+	botFsm := builder.Build(nil, WithGlobalHandler[int](PollAnswerUpdate,
+		func(ctx context.Context, update *tgbotapi.Update) error {
+			handled = update
+			return nil
+		}))
+	if err = botFsm.HandleUpdate(ctx, update); err != nil {
+		t.Fatalf("update error: %s", err)
+	}
+	if handled != update {
+		t.Fatalf("expected poll answer passed to the global handler")
+	}
+}
@@ -0,0 +1,315 @@
+package fsm
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ProblemKind identifies a configuration problem type.
+type ProblemKind string
+
+const (
+	MissingUndefinedStateProblem ProblemKind = "missing_undefined_state"
+	EmptyStateNameProblem        ProblemKind = "empty_state_name"
+	NilStateHandlerProblem       ProblemKind = "nil_state_handler"
+	NilCommandHandlerProblem     ProblemKind = "nil_command_handler"
+	NilPersistenceHandlerProblem ProblemKind = "nil_persistence_handler"
+	DanglingEdgeProblem          ProblemKind = "dangling_edge"
+	DuplicateCommandProblem      ProblemKind = "duplicate_command"
+	UnreachableStateProblem      ProblemKind = "unreachable_state"
+	// Sub-flows or history are used, but PersistenceHandler doesn't implement MetaPersistenceHandler.
+	MissingMetaPersistenceProblem ProblemKind = "missing_meta_persistence"
+	// Builder state has neither Message nor Text, so nothing is sent on entering it.
+	MissingMessageProblem ProblemKind = "missing_message"
+	// Command doesn't declare target states, so its edges are missing in the graph.
+	UndeclaredCommandTargetsProblem ProblemKind = "undeclared_command_targets"
+	// WithMissingStateFallback or WithPanicState option refers to nonexistent state.
+	DanglingOptionStateProblem ProblemKind = "dangling_option_state"
+)
+
+// ValidationProblem describes a single configuration problem.
+type ValidationProblem struct {
+	Kind ProblemKind
+	// Warnings don't prevent FSM from working, but usually point to a mistake.
+	Warning bool
+	// The state problem is related to, if any.
+	State
+	// The command problem is related to, if any.
+	Command string
+	Message string
+}
+
+func (p ValidationProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Kind, p.Message)
+}
+
+// ValidationReport contains all found configuration problems.
+type ValidationReport struct {
+	Problems []ValidationProblem
+}
+
+// Errors returns problems which are not warnings.
+func (r *ValidationReport) Errors() []ValidationProblem {
+	return r.filter(false)
+}
+
+// Warnings returns problems which are warnings.
+func (r *ValidationReport) Warnings() []ValidationProblem {
+	return r.filter(true)
+}
+
+func (r *ValidationReport) HasErrors() bool {
+	return len(r.Errors()) > 0
+}
+
+func (r *ValidationReport) filter(warning bool) []ValidationProblem {
+	var problems []ValidationProblem
+	for _, problem := range r.Problems {
+		if problem.Warning == warning {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+func (r *ValidationReport) add(problem ValidationProblem) {
+	r.Problems = append(r.Problems, problem)
+}
+
+// ValidationError Returned by TryNewBotFsm when FSM configuration has errors.
+type ValidationError struct {
+	*ValidationReport
+}
+
+func (e *ValidationError) Error() string {
+	errs := e.Errors()
+	messages := make([]string, len(errs))
+	for i, problem := range errs {
+		messages[i] = problem.String()
+	}
+	return fmt.Sprintf("invalid fsm configuration: %s", strings.Join(messages, "; "))
+}
+
+// TryNewBotFsm works like NewBotFsm, but validates configuration and returns ValidationError instead of panicking.
+// Warnings don't cause an error.
+func TryNewBotFsm[T any](
+	bot *tgbotapi.BotAPI,
+	configs map[string]StateHandler[T],
+	optFns ...BotFsmOptsFn[T],
+) (*BotFsm[T], error) {
+	botFsm := newBotFsm(bot, configs, optFns...)
+	report := botFsm.Validate()
+	if report.HasErrors() {
+		return nil, &ValidationError{report}
+	}
+	return botFsm, nil
+}
+
+// Validate checks FSM configuration: required states, nil handlers, declared edges (transition rules and
+// TargetStatesProvider results), missing state fallback and panic state pointing to nonexistent states, states
+// unreachable via declared edges, command names differing only in case, sub-flows or history used without
+// MetaPersistenceHandler, builder states without message and commands without declared target states.
+func (b *BotFsm[T]) Validate() *ValidationReport {
+	return b.snapshot().validate()
+}
+
+func (b *BotFsm[T]) validate() *ValidationReport {
+	report := &ValidationReport{}
+	if _, ok := b.configs[UndefinedState]; !ok {
+		report.add(ValidationProblem{
+			Kind:    MissingUndefinedStateProblem,
+			State:   UndefinedState,
+			Message: "undefined state configuration must be provided",
+		})
+	}
+	if _, ok := b.configs[""]; ok {
+		report.add(ValidationProblem{Kind: EmptyStateNameProblem, Message: "empty state configuration forbidden"})
+	}
+	if isNil(b.PersistenceHandler) {
+		report.add(ValidationProblem{Kind: NilPersistenceHandlerProblem, Message: "persistence handler is nil"})
+	}
+	for _, state := range sortedKeys(b.configs) {
+		if isNil(b.configs[state]) {
+			report.add(ValidationProblem{
+				Kind:    NilStateHandlerProblem,
+				State:   state,
+				Message: fmt.Sprintf("state %s handler is nil", state),
+			})
+		}
+		if handler, ok := b.configs[state].(*funcStateHandler[T]); ok && handler != nil && handler.messageFn == nil {
+			report.add(ValidationProblem{
+				Kind:    MissingMessageProblem,
+				Warning: true,
+				State:   state,
+				Message: fmt.Sprintf("state %s has no message", state),
+			})
+		}
+	}
+	b.validateOptionStates(report)
+	b.validateCommands(report)
+	b.validateEdges(report)
+	b.validateMetaPersistence(report)
+	return report
+}
+
+// validateOptionStates reports missing state fallback and panic state, which refer to nonexistent states.
+func (b *BotFsm[T]) validateOptionStates(report *ValidationReport) {
+	optionStates := []struct {
+		option string
+		state  State
+	}{
+		{"missing state fallback", b.missingStateFallback},
+		{"panic state", b.panicState},
+	}
+	for _, optionState := range optionStates {
+		if _, ok := b.configs[optionState.state]; !ok && optionState.state != "" {
+			report.add(ValidationProblem{
+				Kind:    DanglingOptionStateProblem,
+				State:   optionState.state,
+				Message: fmt.Sprintf("%s refers to nonexistent state %s", optionState.option, optionState.state),
+			})
+		}
+	}
+}
+
+// validateMetaPersistence reports history and sub-flows (states implementing ReturnHandler) which can't be used
+// without MetaPersistenceHandler.
+func (b *BotFsm[T]) validateMetaPersistence(report *ValidationReport) {
+	if isNil(b.PersistenceHandler) || b.metaPersisted() {
+		return
+	}
+	if b.historySize > 0 {
+		report.add(ValidationProblem{
+			Kind:    MissingMetaPersistenceProblem,
+			Message: "history requires persistence handler implementing MetaPersistenceHandler",
+		})
+	}
+	for _, state := range sortedKeys(b.configs) {
+		if _, ok := handlerAs[ReturnHandler[T]](b.configs[state]); ok {
+			report.add(ValidationProblem{
+				Kind:    MissingMetaPersistenceProblem,
+				State:   state,
+				Message: fmt.Sprintf("state %s sub-flows require persistence handler implementing MetaPersistenceHandler", state),
+			})
+		}
+	}
+}
+
+func (b *BotFsm[T]) validateCommands(report *ValidationReport) {
+	commandsByLowercase := make(map[string]string, len(b.commands))
+	for _, command := range sortedKeys(b.commands) {
+		if isNil(b.commands[command]) {
+			report.add(ValidationProblem{
+				Kind:    NilCommandHandlerProblem,
+				Command: command,
+				Message: fmt.Sprintf("command %s handler is nil", command),
+			})
+		}
+		lowercase := strings.ToLower(command)
+		if duplicate, ok := commandsByLowercase[lowercase]; ok {
+			report.add(ValidationProblem{
+				Kind:    DuplicateCommandProblem,
+				Command: command,
+				Message: fmt.Sprintf("commands %s and %s differ only in case", duplicate, command),
+			})
+			continue
+		}
+		commandsByLowercase[lowercase] = command
+	}
+}
+
+func (b *BotFsm[T]) validateEdges(report *ValidationReport) {
+	graph := b.graph()
+	for _, edge := range graph.Edges {
+		for _, state := range []State{edge.From, edge.To} {
+			if _, ok := b.configs[state]; !ok && state != AnyState {
+				report.add(ValidationProblem{
+					Kind:    DanglingEdgeProblem,
+					State:   state,
+					Message: fmt.Sprintf("edge %s -> %s refers to nonexistent state %s", edge.From, edge.To, state),
+				})
+			}
+		}
+	}
+
+	// Reachability and command targets make sense only when edges are declared.
+	if len(graph.Edges) == 0 {
+		return
+	}
+	b.validateCommandTargets(report)
+	reachable := map[State]struct{}{UndefinedState: {}}
+	queue := []State{UndefinedState}
+	for _, edge := range graph.Edges {
+		if _, ok := reachable[edge.To]; edge.From == AnyState && !ok {
+			reachable[edge.To] = struct{}{}
+			queue = append(queue, edge.To)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, edge := range graph.Edges {
+			if _, ok := reachable[edge.To]; edge.From == state && !ok {
+				reachable[edge.To] = struct{}{}
+				queue = append(queue, edge.To)
+			}
+		}
+	}
+	for _, state := range sortedKeys(b.configs) {
+		if _, ok := reachable[state]; !ok {
+			report.add(ValidationProblem{
+				Kind:    UnreachableStateProblem,
+				Warning: true,
+				State:   state,
+				Message: fmt.Sprintf("state %s is unreachable via declared edges", state),
+			})
+		}
+	}
+}
+
+// validateCommandTargets reports commands without declared target states. Back command is skipped, since it leads to
+// already visited states.
+func (b *BotFsm[T]) validateCommandTargets(report *ValidationReport) {
+	for _, command := range sortedKeys(b.commands) {
+		commandHandler := b.commands[command]
+		if _, ok := commandHandler.(BackCommandHandler[T]); ok || isNil(commandHandler) {
+			continue
+		}
+		if targetStatesProvider, ok := handlerAs[TargetStatesProvider](commandHandler); ok &&
+			len(targetStatesProvider.TargetStates()) > 0 {
+			continue
+		}
+		report.add(ValidationProblem{
+			Kind:    UndeclaredCommandTargetsProblem,
+			Warning: true,
+			Command: command,
+			Message: fmt.Sprintf("command %s doesn't declare target states, its edges are missing in the graph", command),
+		})
+	}
+}
+
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() { //nolint:exhaustive // other kinds cannot be nil
+	case reflect.Ptr, reflect.Map, reflect.Func, reflect.Interface, reflect.Slice, reflect.Chan:
+		return value.IsNil()
+	default:
+		return false
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,55 @@
+package fsm
+
+import (
+	"context"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestValidateCommandTargets(t *testing.T) {
+	builder := New[int]()
+	builder.State(UndefinedState).Text("start").Targets("help")
+	builder.State("help").Text("help")
+	builder.CommandTo("help", "help").
+		Command("random", func(ctx context.Context, update *tgbotapi.Update, data int) (Transition, int) {
+			return StateTransition("help"), data
+		})
+	configs, commands := builder.Configs()
+	commands["back"] = BackCommandHandler[int]{}
+
+	report := NewBotFsm(nil, configs, WithCommands[int](commands), WithHistory[int](5)).Validate()
+	warnings := report.Warnings()
+	if len(warnings) != 1 || warnings[0].Kind != UndeclaredCommandTargetsProblem || warnings[0].Command != "random" {
+		t.Fatalf("expected undeclared command targets warning, got %v", warnings)
+	}
+}
+
+func TestValidateOptionStates(t *testing.T) {
+	configs := map[State]StateHandler[int]{UndefinedState: &funcStateHandler[int]{}}
+	tests := []struct {
+		name   string
+		optFns []BotFsmOptsFn[int]
+		states []State
+	}{
+		{"existing states", []BotFsmOptsFn[int]{
+			WithMissingStateFallback[int](UndefinedState),
+			WithPanicState[int](UndefinedState),
+		}, nil},
+		{"missing fallback", []BotFsmOptsFn[int]{WithMissingStateFallback[int]("menu")}, []State{"menu"}},
+		{"misspelled panic state", []BotFsmOptsFn[int]{WithPanicState[int]("opps")}, []State{"opps"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var states []State
+			for _, problem := range NewBotFsm(nil, configs, test.optFns...).Validate().Errors() {
+				if problem.Kind == DanglingOptionStateProblem {
+					states = append(states, problem.State)
+				}
+			}
+			if len(states) != len(test.states) || (len(states) > 0 && states[0] != test.states[0]) {
+				t.Fatalf("expected problems for %v, got %v", test.states, states)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package fsm
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// InvalidInitDataError Returned when web app initData can't be parsed, its hash doesn't match or it's expired.
+type InvalidInitDataError struct {
+	Reason string
+}
+
+func (e *InvalidInitDataError) Error() string {
+	return fmt.Sprintf("invalid web app init data: %s", e.Reason)
+}
+
+// WebAppInfo describes the web app opened by a keyboard button.
+type WebAppInfo struct {
+	URL string `json:"url"`
+}
+
+// WebAppData is the data sent by the web app (Telegram.WebApp.sendData) in a service message.
+type WebAppData struct {
+	Data string `json:"data"`
+	// Text of the keyboard button the web app was opened with.
+	ButtonText string `json:"button_text"`
+}
+
+// DecodeWebAppData decodes JSON web app data.
+func DecodeWebAppData[D any](webAppData WebAppData) (D, error) {
+	var data D
+	err := json.Unmarshal([]byte(webAppData.Data), &data)
+	return data, err
+}
+
+// WebAppDataFn defines state switching logic for the data sent by the web app.
+type WebAppDataFn[T any] func(ctx context.Context, webAppData WebAppData, data T) (Transition, T)
+
+// WebAppDataHandler may be implemented by StateHandler to handle web app data messages instead of TransitionFn. Web
+// app data is available only for updates passed to HandleRawUpdate, since tgbotapi doesn't support it.
+type WebAppDataHandler[T any] interface {
+	WebAppDataFn(ctx context.Context, webAppData WebAppData, data T) (Transition, T)
+}
+
+// WebAppKeyboardButton is tgbotapi.KeyboardButton which can open a web app. tgbotapi doesn't support web_app field.
+type WebAppKeyboardButton struct {
+	tgbotapi.KeyboardButton
+	WebApp *WebAppInfo `json:"web_app,omitempty"`
+}
+
+// WebAppInlineKeyboardButton is tgbotapi.InlineKeyboardButton which can open a web app. tgbotapi doesn't support
+// web_app field.
+type WebAppInlineKeyboardButton struct {
+	tgbotapi.InlineKeyboardButton
+	WebApp *WebAppInfo `json:"web_app,omitempty"`
+}
+
+// WebAppReplyKeyboardMarkup is tgbotapi.ReplyKeyboardMarkup with WebAppKeyboardButton buttons.
+type WebAppReplyKeyboardMarkup struct {
+	Keyboard              [][]WebAppKeyboardButton `json:"keyboard"`
+	ResizeKeyboard        bool                     `json:"resize_keyboard"`
+	OneTimeKeyboard       bool                     `json:"one_time_keyboard"`
+	InputFieldPlaceholder string                   `json:"input_field_placeholder,omitempty"`
+	Selective             bool                     `json:"selective"`
+}
+
+// WebAppInlineKeyboardMarkup is tgbotapi.InlineKeyboardMarkup with WebAppInlineKeyboardButton buttons.
+type WebAppInlineKeyboardMarkup struct {
+	InlineKeyboard [][]WebAppInlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// NewWebAppKeyboardButton creates the keyboard button opening the web app. Only web apps opened this way can send
+// data back to the bot.
+func NewWebAppKeyboardButton(text, url string) WebAppKeyboardButton {
+	return WebAppKeyboardButton{
+		KeyboardButton: tgbotapi.NewKeyboardButton(text),
+		WebApp:         &WebAppInfo{URL: url},
+	}
+}
+
+// NewWebAppInlineKeyboardButton creates the inline keyboard button opening the web app.
+func NewWebAppInlineKeyboardButton(text, url string) WebAppInlineKeyboardButton {
+	return WebAppInlineKeyboardButton{
+		InlineKeyboardButton: tgbotapi.InlineKeyboardButton{Text: text},
+		WebApp:               &WebAppInfo{URL: url},
+	}
+}
+
+// WebAppMessageConfig simplifies MessageConfig creation for states opening the web app. The message has a single
+// button keyboard, so the web app can send data back to the bot.
+func WebAppMessageConfig(text, buttonText, url string) MessageConfig {
+	messageConfig := TextMessageConfig(text)
+	messageConfig.ReplyMarkup = WebAppReplyKeyboardMarkup{
+		Keyboard:       [][]WebAppKeyboardButton{{NewWebAppKeyboardButton(buttonText, url)}},
+		ResizeKeyboard: true,
+	}
+	return messageConfig
+}
+
+// WebAppInitData contains validated web app initData fields.
+type WebAppInitData struct {
+	QueryId string
+	// The user who opened the web app.
+	User         *tgbotapi.User
+	ChatType     string
+	ChatInstance string
+	StartParam   string
+	AuthDate     time.Time
+	// All initData fields, including the ones not listed above.
+	Values url.Values
+}
+
+// ValidateWebAppInitData checks initData (Telegram.WebApp.initData) hash against the bot token and parses it. initData
+// older than maxAge is rejected, unless maxAge is 0.
+func ValidateWebAppInitData(initData, botToken string, maxAge time.Duration) (*WebAppInitData, error) {
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return nil, &InvalidInitDataError{err.Error()}
+	}
+	hash := values.Get("hash")
+	if hash == "" {
+		return nil, &InvalidInitDataError{"hash is missing"}
+	}
+	if !hmac.Equal([]byte(hash), []byte(getInitDataHash(values, botToken))) {
+		return nil, &InvalidInitDataError{"hash mismatch"}
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return nil, &InvalidInitDataError{"auth_date is invalid"}
+	}
+	res := &WebAppInitData{
+		QueryId:      values.Get("query_id"),
+		ChatType:     values.Get("chat_type"),
+		ChatInstance: values.Get("chat_instance"),
+		StartParam:   values.Get("start_param"),
+		AuthDate:     time.Unix(authDate, 0),
+		Values:       values,
+	}
+	if maxAge != 0 && time.Since(res.AuthDate) > maxAge {
+		return nil, &InvalidInitDataError{"init data is expired"}
+	}
+	if user := values.Get("user"); user != "" {
+		res.User = &tgbotapi.User{}
+		err = json.Unmarshal([]byte(user), res.User)
+		if err != nil {
+			return nil, &InvalidInitDataError{"user is invalid"}
+		}
+	}
+	return res, nil
+}
+
+// getInitDataHash returns hex-encoded initData hash according to the web apps documentation: HMAC-SHA256 of sorted
+// "key=value" lines, keyed with HMAC-SHA256 of the bot token keyed with "WebAppData".
+func getInitDataHash(values url.Values, botToken string) string {
+	lines := make([]string, 0, len(values))
+	for key := range values {
+		if key != "hash" {
+			lines = append(lines, key+"="+values.Get(key))
+		}
+	}
+	sort.Strings(lines)
+
+	secretKey := hmac.New(sha256.New, []byte("WebAppData"))
+	secretKey.Write([]byte(botToken))
+	hash := hmac.New(sha256.New, secretKey.Sum(nil))
+	hash.Write([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// WebAppSession returns the key, state and data of the session of the web app user private chat with the bot. Use it
+// in the web app backend along with GoToSession to continue the conversation.
+func (b *BotFsm[T]) WebAppSession(ctx context.Context, initData *WebAppInitData) (SessionKey, State, T, error) {
+	var emptyData T
+	if initData.User == nil {
+		return SessionKey{}, "", emptyData, &InvalidInitDataError{"user is missing"}
+	}
+	sessionKey := b.getPrivateSessionKey(initData.User.ID)
+	state, data, _, err := b.snapshot().loadState(ctx, sessionKey)
+	if err != nil {
+		return SessionKey{}, "", emptyData, err
+	}
+	return sessionKey, state, data, nil
+}
+
+// webAppDataProvider passes web app data to WebAppDataFn.
+type webAppDataProvider[T any] struct {
+	handler    WebAppDataHandler[T]
+	webAppData WebAppData
+}
+
+func (p *webAppDataProvider[T]) TransitionFn(ctx context.Context, update *tgbotapi.Update, data T) (Transition, T) {
+	return p.handler.WebAppDataFn(ctx, p.webAppData, data)
+}
@@ -0,0 +1,80 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestWebAppSessionWithReload(t *testing.T) {
+	_, bot := newFakeTelegram(t)
+	builder := New[int]()
+	builder.State(UndefinedState).Text("start")
+	botFsm := builder.Build(bot, WithMissingStateFallback[int](UndefinedState))
+	configs, commands := builder.Configs()
+	initData := &WebAppInitData{User: &tgbotapi.User{ID: 1}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := botFsm.Reload(configs, commands); err != nil {
+				t.Errorf("reload error: %s", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, _, _, err := botFsm.WebAppSession(context.Background(), initData); err != nil {
+				t.Errorf("session error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestValidateWebAppInitData(t *testing.T) {
+	// The hash is computed independently according to the web apps documentation.
+	const botToken = "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11"
+	const initData = "query_id=AAHdF6IQAAAAAN0XohDhrOrc" +
+		"&user=%7B%22id%22%3A279058397%2C%22first_name%22%3A%22Vladislav%22%2C%22username%22%3A%22vdkfrost%22%2C" +
+		"%22language_code%22%3A%22ru%22%7D&auth_date=1662771648" +
+		"&hash=53700da139c813d1b3e3a236574c775b2eb215bb63ec12bd931b7e8fc5455cd2"
+	tests := []struct {
+		name     string
+		initData string
+		botToken string
+		maxAge   time.Duration
+		reason   string
+	}{
+		{"valid", initData, botToken, 0, ""},
+		{"other bot token", initData, "654321:ABC", 0, "hash mismatch"},
+		{"tampered data", strings.Replace(initData, "279058397", "279058398", 1), botToken, 0, "hash mismatch"},
+		{"missing hash", "auth_date=1662771648", botToken, 0, "hash is missing"},
+		{"expired", initData, botToken, time.Hour, "init data is expired"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := ValidateWebAppInitData(test.initData, test.botToken, test.maxAge)
+			if test.reason != "" {
+				var initDataErr *InvalidInitDataError
+				if !errors.As(err, &initDataErr) || initDataErr.Reason != test.reason {
+					t.Fatalf("expected %q error, got %v", test.reason, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if res.QueryId != "AAHdF6IQAAAAAN0XohDhrOrc" || res.User == nil || res.User.ID != 279058397 ||
+				res.User.UserName != "vdkfrost" || res.AuthDate.Unix() != 1662771648 {
+				t.Fatalf("unexpected init data: %+v", res)
+			}
+		})
+	}
+}